@@ -5,11 +5,13 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
-	"math/rand"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -20,7 +22,7 @@ var verbflag = flag.Int("v", 0, "Verbose trace output level")
 var numitflag = flag.Int("n", 1000, "Number of tests to generate")
 var seedflag = flag.Int64("s", 10101, "Random seed")
 var tagflag = flag.String("t", "gen", "Prefix name of go files/pkgs to generate")
-var outdirflag = flag.String("o", "", "Output directory for generated files")
+var outdirflag = flag.String("o", "", "Output directory for generated files, or a file ending in .txtar or .zip to write a single archive instead")
 var pkgpathflag = flag.String("p", "gen", "Base package path for generated files")
 var numtpkflag = flag.Int("q", 1, "Number of test packages")
 var fcnmaskflag = flag.String("M", "", "Mask containing list of fcn numbers to emit")
@@ -28,6 +30,10 @@ var pkmaskflag = flag.String("P", "", "Mask containing list of pkg numbers to em
 
 var reflectflag = flag.Bool("reflect", true, "Include testing of reflect.Call.")
 var deferflag = flag.Bool("defer", true, "Include testing of defer stmts.")
+var goflag = flag.Bool("go", true, "Include testing of go stmts.")
+var mutateflag = flag.Bool("mutate", true, "Include testing of writes made through pointer, slice and map params.")
+var slicealiasflag = flag.Bool("slicealias", true, "Include testing of slice params with len < cap that share a backing array.")
+var panicflag = flag.Bool("panic", true, "Include testing of panic/recover and writes to named results in deferred funcs.")
 var recurflag = flag.Bool("recur", true, "Include testing of recursive calls.")
 var takeaddrflag = flag.Bool("takeaddr", true, "Include functions that take the address of their parameters and results.")
 var methodflag = flag.Bool("method", true, "Include testing of method calls.")
@@ -38,6 +44,21 @@ var pragmaflag = flag.String("pragma", "", "Tag generated test routines with pra
 var maxfailflag = flag.Int("maxfail", 10, "Maximum runtime failures before test self-terminates")
 var stackforceflag = flag.Bool("forcestackgrowth", true, "Use hooks to force stack growth.")
 var randctlflag = flag.Int("randctl", generator.RandCtlChecks|generator.RandCtlPanic, "Wraprand control flag")
+var goarchflag = flag.String("goarch", defaultGoarchs(), "Comma-separated list of target GOARCH values. With -regboundary, -spec or -from-pkg and more than one target, code is generated separately for each target, in a subdirectory of -o named for it.")
+var testparflag = flag.Int("testpar", 0, "If nonzero, run each test in its own goroutine, with at most this many running at once.")
+var repeatflag = flag.Int("repeat", 1, "Number of times the generated main runs the full set of tests.")
+var repeathookflag = flag.String("repeathook", "gc", "Action between iterations of the -repeat loop: one of "+strings.Join(generator.RepeatHooks, ", ")+".")
+var gcinjectflag = flag.Int("gcinject", 0, "Percentage of injection points within test routines at which to call runtime.GC().")
+var runflag = flag.Bool("run", false, "Build and run the generated code for each -goarch target.")
+var corpusflag = flag.String("corpus", "", "Directory in which -run saves a record of each failing case, for use with 'cabi-testgen replay'.")
+var regboundaryflag = flag.Int("regboundary", 0, "Percentage of test routines with signatures at the edge of the available argument registers.")
+var typecheckflag = flag.Bool("typecheck", false, "Type-check the generated code in-process, reporting errors against the test function responsible.")
+var parflag = flag.Int("j", 1, "Number of test packages to generate in parallel.")
+var zerosizeflag = flag.Int("zerosize", 0, "Percentage of test routines with zero-size params, returns and receivers in every position.")
+var regenflag = flag.String("regen", "", "Regenerate the single test function 'pkg:fn' (package and function index) recorded in the -manifest file.")
+var manifestflag = flag.String("manifest", "", "Manifest file written by a previous run, for use with -regen.")
+var specflag = flag.String("spec", "", "File of Go func type expressions (one per line) to use as test function signatures in place of random ones; -n is ignored.")
+var frompkgflag = flag.String("from-pkg", "", "Import path of a Go package whose exported function and method signatures are used as test function signatures in place of random ones; -n is ignored.")
 
 // for testcase minimization
 var utilsinlineflag = flag.Bool("inlutils", false, "Emit inline utils code (for minimization)")
@@ -53,17 +74,25 @@ func usage(msg string) {
 	if len(msg) > 0 {
 		fmt.Fprintf(os.Stderr, "error: %s\n", msg)
 	}
-	fmt.Fprintf(os.Stderr, "usage: cabi-testgen [flags]\n\n")
+	fmt.Fprintf(os.Stderr, "usage: cabi-testgen [flags]\n")
+	fmt.Fprintf(os.Stderr, "       cabi-testgen [flags] replay corpusdir\n\n")
 	flag.PrintDefaults()
 	fmt.Fprintf(os.Stderr, "Example:\n\n")
 	fmt.Fprintf(os.Stderr, "  cabi-testgen -n 500 -s 10101 -o gendir\n\n")
 	fmt.Fprintf(os.Stderr, "  \tgenerates Go with 500 test cases into a set of subdirs\n")
-	fmt.Fprintf(os.Stderr, "  \tin 'gendir', using random see 10101\n")
+	fmt.Fprintf(os.Stderr, "  \tin 'gendir', using random see 10101\n\n")
+	fmt.Fprintf(os.Stderr, "  cabi-testgen replay corpusdir\n\n")
+	fmt.Fprintf(os.Stderr, "  \tregenerates, builds and runs each failing case saved\n")
+	fmt.Fprintf(os.Stderr, "  \tin 'corpusdir' by -corpus, and reports which still fail\n")
 
 	os.Exit(2)
 }
 
-func setupTunables() {
+// setupTunables installs the tunables selected on the command line
+// for generating code that targets 'goarch'. When generating for
+// several targets ('perarch'), a target without a register ABI gets
+// no register boundary functions rather than an error.
+func setupTunables(goarch string, perarch bool) {
 	tunables := generator.DefaultTunables()
 	if !*reflectflag {
 		tunables.DisableReflectionCalls()
@@ -71,6 +100,18 @@ func setupTunables() {
 	if !*deferflag {
 		tunables.DisableDefer()
 	}
+	if !*goflag {
+		tunables.DisableGo()
+	}
+	if !*panicflag {
+		tunables.DisablePanic()
+	}
+	if !*mutateflag {
+		tunables.DisableMutate()
+	}
+	if !*slicealiasflag {
+		tunables.DisableSliceAlias()
+	}
 	if !*recurflag {
 		tunables.DisableRecursiveCalls()
 	}
@@ -86,20 +127,82 @@ func setupTunables() {
 	if *outlimitflag != -1 {
 		tunables.LimitOutputs(*outlimitflag)
 	}
+	if err := tunables.SetGoarch(goarch); err != nil {
+		usage(err.Error())
+	}
+	if err := tunables.EnableRegBoundary(*regboundaryflag); err != nil {
+		if !perarch || !errors.Is(err, generator.ErrNoRegABI) {
+			usage(err.Error())
+		}
+		verb(0, "warning: %v, so %s gets no register boundary functions", err, goarch)
+		tunables.EnableRegBoundary(0)
+	}
+	if err := tunables.InjectGC(*gcinjectflag); err != nil {
+		usage(err.Error())
+	}
+	if err := tunables.EnableZeroSize(*zerosizeflag); err != nil {
+		usage(err.Error())
+	}
 	generator.SetTunables(tunables)
 }
 
+// regenConfig returns a copy of 'c' set up to regenerate the single
+// function selected by -regen, using the settings recorded in the
+// -manifest file in place of those from the command line.
+func regenConfig(c generator.GenConfig) generator.GenConfig {
+	if *manifestflag == "" {
+		usage("-regen requires -manifest")
+	}
+	if *fcnmaskflag != "" || *pkmaskflag != "" {
+		usage("-regen can't be combined with -M or -P")
+	}
+	var pkg, fn int
+	if _, err := fmt.Sscanf(*regenflag, "%d:%d", &pkg, &fn); err != nil {
+		usage(fmt.Sprintf("malformed -regen value %q (want pkg:fn)", *regenflag))
+	}
+	f, err := os.Open(*manifestflag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	m, err := generator.ReadManifest(f)
+	f.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+	rc, tunables, err := m.RegenConfig(pkg, fn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	verb(1, "regenerating %s from seed %d", *regenflag, m.Seed)
+	generator.SetTunables(tunables)
+	rc.OutDir = c.OutDir
+	rc.Output = c.Output
+	rc.RunGoImports = c.RunGoImports
+	rc.Stats = c.Stats
+	rc.Parallelism = c.Parallelism
+	rc.TypeCheck = c.TypeCheck
+	return rc
+}
+
 func main() {
 	log.SetFlags(0)
 	log.SetPrefix("cabi-testgen: ")
 	flag.Parse()
 	generator.Verbctl = *verbflag
+	if flag.NArg() != 0 && flag.Arg(0) == "replay" {
+		if flag.NArg() != 2 {
+			usage("replay takes a single corpus directory")
+		}
+		if !replay(flag.Arg(1)) {
+			os.Exit(1)
+		}
+		return
+	}
 	if *outdirflag == "" {
 		usage("select an output directory with -o flag")
 	}
 	verb(1, "in main verblevel=%d", *verbflag)
 	verb(1, "seed is %d", *seedflag)
-	rand.Seed(*seedflag)
 	if flag.NArg() != 0 {
 		usage("unknown extra arguments")
 	}
@@ -134,21 +237,154 @@ func main() {
 		}
 		return m
 	}
+	validhook := false
+	for _, h := range generator.RepeatHooks {
+		if h == *repeathookflag {
+			validhook = true
+		}
+	}
+	if !validhook {
+		usage(fmt.Sprintf("unknown -repeathook value %q", *repeathookflag))
+	}
+
 	fcnmask := mkmask(*fcnmaskflag, "fcn")
 	pkmask := mkmask(*pkmaskflag, "pkg")
 
 	verb(2, "pkg mask is %v", pkmask)
 	verb(2, "fn mask is %v", fcnmask)
 
+	goarchs := parseGoarchs(*goarchflag)
+	if len(goarchs) == 0 {
+		usage("empty -goarch list")
+	}
+	// Register boundary functions, and the sizes of int and uint in
+	// signatures from -spec or -from-pkg, depend on the target, so
+	// with more than one target the code is generated once per
+	// target, into a subdirectory of the output directory.
+	perarch := len(goarchs) > 1 && *regenflag == "" &&
+		(*regboundaryflag != 0 || *specflag != "" || *frompkgflag != "")
+
 	verb(1, "starting generation")
-	setupTunables()
-	errs := generator.Generate(*tagflag, *outdirflag, *pkgpathflag,
-		*numitflag, *numtpkflag, *seedflag, *pragmaflag,
-		fcnmask, pkmask, *utilsinlineflag, *maxfailflag, *stackforceflag,
-		*randctlflag, *goimpflag)
-	if errs != 0 {
-		log.Fatal("errors during generation")
-	}
-	verb(0, "... files written to directory %s", *outdirflag)
+	var archive *generator.ArchiveOutput
+	var archfile *os.File
+	if strings.HasSuffix(*outdirflag, ".txtar") || strings.HasSuffix(*outdirflag, ".zip") {
+		if *runflag {
+			usage("-run requires an output directory")
+		}
+		if *goimpflag {
+			usage("-goimports requires an output directory")
+		}
+		if perarch {
+			usage("an archive can't hold code generated for more than one -goarch target")
+		}
+		var err error
+		if archfile, err = os.Create(*outdirflag); err != nil {
+			log.Fatal(err)
+		}
+		if strings.HasSuffix(*outdirflag, ".zip") {
+			archive = generator.NewZipOutput(archfile)
+		} else {
+			archive = generator.NewTxtarOutput(archfile)
+		}
+	}
+	var out generator.Output
+	if archive != nil {
+		out = archive
+	}
+	cfg := generator.GenConfig{
+		Tag:              *tagflag,
+		OutDir:           *outdirflag,
+		Output:           out,
+		PkgPath:          *pkgpathflag,
+		NumTestFunctions: *numitflag,
+		NumTestPackages:  *numtpkflag,
+		Seed:             *seedflag,
+		Pragma:           *pragmaflag,
+		FcnMask:          fcnmask,
+		PkgMask:          pkmask,
+		UtilsInline:      *utilsinlineflag,
+		MaxFail:          *maxfailflag,
+		ForceStackGrowth: *stackforceflag,
+		RandCtl:          *randctlflag,
+		RunGoImports:     *goimpflag,
+		TestParallelism:  *testparflag,
+		Repeat:           *repeatflag,
+		RepeatHook:       *repeathookflag,
+		Parallelism:      *parflag,
+		TypeCheck:        *typecheckflag,
+	}
+	if *specflag != "" && *frompkgflag != "" {
+		usage("-spec and -from-pkg can't be used together")
+	}
+	if *frompkgflag != "" {
+		specs, skipped, err := generator.SpecsFromPackage(*frompkgflag)
+		for _, s := range skipped {
+			verb(1, "skipping %s", s)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		verb(0, "%d signatures skipped from %s", len(skipped), *frompkgflag)
+		cfg.Specs = specs
+	}
+	if *specflag != "" {
+		src, err := ioutil.ReadFile(*specflag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if cfg.Specs, err = generator.ParseSpecs(*specflag, src); err != nil {
+			log.Fatal(err)
+		}
+	}
+	targets := [][]string{goarchs}
+	if perarch {
+		targets = nil
+		for _, goarch := range goarchs {
+			targets = append(targets, []string{goarch})
+		}
+	}
+	runfailed := false
+	for _, target := range targets {
+		setupTunables(target[0], perarch)
+		c := cfg
+		var stats generator.GenStats
+		c.Stats = &stats
+		if perarch {
+			c.OutDir = filepath.Join(*outdirflag, target[0])
+		}
+		if *regenflag != "" {
+			c = regenConfig(c)
+		}
+		errs := generator.GenerateFromConfig(c)
+		if errs != 0 {
+			log.Fatal("errors during generation")
+		}
+		if *zerosizeflag != 0 {
+			verb(0, "%s", stats.String())
+		}
+		if archive == nil {
+			verb(0, "... files written to directory %s", c.OutDir)
+		}
+		if *runflag {
+			if failed := runGenerated(c.OutDir, c.Tag, target); len(failed) != 0 {
+				if *corpusflag != "" {
+					saveFailure(c.OutDir, target, failed)
+				}
+				runfailed = true
+			}
+		}
+	}
+	if archive != nil {
+		if err := archive.Close(); err != nil {
+			log.Fatal(err)
+		}
+		if err := archfile.Close(); err != nil {
+			log.Fatal(err)
+		}
+		verb(0, "... files written to archive %s", *outdirflag)
+	}
+	if runfailed {
+		log.Fatal("failures building or running generated code")
+	}
 	verb(1, "leaving main")
 }
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// archResult records the outcome of building and running the
+// generated program for a specific target architecture.
+type archResult struct {
+	goarch string
+	stage  string
+	ok     bool
+	output string
+}
+
+func (r archResult) String() string {
+	status := "PASS"
+	if !r.ok {
+		status = "FAIL"
+	}
+	return fmt.Sprintf("%-8s %s (%s)", r.goarch, status, r.stage)
+}
+
+// canExec returns true if binaries built for the specified GOARCH
+// can be run natively on the host.
+func canExec(goarch string) bool {
+	return canExecOn(runtime.GOOS, runtime.GOARCH, goarch)
+}
+
+// canExecOn returns true if binaries built for the specified GOARCH
+// can be run natively on a goos/hostarch host.
+func canExecOn(goos, hostarch, goarch string) bool {
+	if goarch == hostarch {
+		return true
+	}
+	return goos == "linux" && hostarch == "amd64" && goarch == "386"
+}
+
+// buildAndRun builds the generated program in 'dir' for the
+// specified target architecture, then runs it if the host is capable
+// of executing the result.
+func buildAndRun(dir string, tag string, goarch string) archResult {
+	res := archResult{goarch: goarch, stage: "build"}
+	exe := filepath.Join(dir, tag+"Main."+goarch)
+	defer os.Remove(exe)
+	verb(1, "building %s for GOARCH=%s", dir, goarch)
+	args := []string{"build", "-o", exe}
+	if needLinknameFlag(goVersion()) {
+		args = append(args, "-ldflags=-checklinkname=0")
+	}
+	cmd := exec.Command("go", append(args, ".")...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOARCH="+goarch)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		res.output = string(out)
+		return res
+	}
+	if !canExec(goarch) {
+		res.stage = "build only, can't run on host"
+		res.ok = true
+		return res
+	}
+	res.stage = "run"
+	verb(1, "running %s", exe)
+	cmd = exec.Command(exe)
+	cmd.Dir = dir
+	out, err = cmd.CombinedOutput()
+	res.output = string(out)
+	res.ok = err == nil
+	return res
+}
+
+// runGenerated builds and runs the generated program in 'dir' once
+// for each target architecture, reporting per-arch results. Return
+// value is the list of results for builds or runs that failed.
+func runGenerated(dir string, tag string, goarchs []string) []archResult {
+	results := []archResult{}
+	for _, goarch := range goarchs {
+		results = append(results, buildAndRun(dir, tag, goarch))
+	}
+	failed := []archResult{}
+	for _, r := range results {
+		fmt.Println(r.String())
+		if !r.ok {
+			failed = append(failed, r)
+			fmt.Fprintf(os.Stderr, "%s", r.output)
+		}
+	}
+	return failed
+}
+
+// toolchainVersion returns the output of "go version", identifying
+// the toolchain used to build the generated code.
+func toolchainVersion() string {
+	out, err := exec.Command("go", "version").Output()
+	if err != nil {
+		return "unknown"
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// goVersion returns the version of the toolchain used to build the
+// generated code, e.g. "go1.22.3".
+func goVersion() string {
+	out, err := exec.Command("go", "env", "GOVERSION").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// needLinknameFlag returns true if toolchain version 'v' (as reported
+// by goVersion) rejects pull-only linkname references into the
+// runtime unless linked with -checklinkname=0, as is the case from
+// Go 1.23 on. Generated code that forces stack growth refers to
+// runtime.gcTestMoveStackOnNextCall in this way. Development
+// toolchains are assumed to be recent.
+func needLinknameFlag(v string) bool {
+	if strings.HasPrefix(v, "devel") {
+		return true
+	}
+	rest := strings.TrimPrefix(v, "go1.")
+	if rest == v {
+		return false
+	}
+	n := 0
+	for n < len(rest) && rest[n] >= '0' && rest[n] <= '9' {
+		n++
+	}
+	minor, err := strconv.Atoi(rest[:n])
+	return err == nil && minor >= 23
+}
+
+// defaultGoarchs returns the default list of target architectures:
+// the host architecture, plus 386 on linux/amd64 (where 32-bit
+// binaries run natively).
+func defaultGoarchs() string {
+	return defaultGoarchsOn(runtime.GOOS, runtime.GOARCH)
+}
+
+// defaultGoarchsOn returns the default list of target architectures
+// for a goos/hostarch host.
+func defaultGoarchsOn(goos, hostarch string) string {
+	if goos == "linux" && hostarch == "amd64" {
+		return "amd64,386"
+	}
+	return hostarch
+}
+
+// parseGoarchs splits a comma-separated list of GOARCH values.
+func parseGoarchs(arg string) []string {
+	rv := []string{}
+	for _, a := range strings.Split(arg, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			rv = append(rv, a)
+		}
+	}
+	return rv
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseGoarchs(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want []string
+	}{
+		{"", []string{}},
+		{",", []string{}},
+		{"amd64", []string{"amd64"}},
+		{"amd64,386", []string{"amd64", "386"}},
+		{" amd64 , 386 ,", []string{"amd64", "386"}},
+		{"arm64,,riscv64", []string{"arm64", "riscv64"}},
+	}
+	for _, tc := range tests {
+		if got := parseGoarchs(tc.arg); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseGoarchs(%q) = %q, want %q", tc.arg, got, tc.want)
+		}
+	}
+}
+
+func TestDefaultGoarchs(t *testing.T) {
+	tests := []struct {
+		goos, hostarch string
+		want           string
+	}{
+		{"linux", "amd64", "amd64,386"},
+		{"linux", "arm64", "arm64"},
+		{"linux", "386", "386"},
+		{"darwin", "amd64", "amd64"},
+		{"darwin", "arm64", "arm64"},
+		{"windows", "amd64", "amd64"},
+	}
+	for _, tc := range tests {
+		if got := defaultGoarchsOn(tc.goos, tc.hostarch); got != tc.want {
+			t.Errorf("defaultGoarchsOn(%q, %q) = %q, want %q", tc.goos, tc.hostarch, got, tc.want)
+		}
+	}
+	for _, goarch := range parseGoarchs(defaultGoarchs()) {
+		if !canExec(goarch) {
+			t.Errorf("default target %s can't run on the host", goarch)
+		}
+	}
+}
+
+func TestCanExec(t *testing.T) {
+	tests := []struct {
+		goos, hostarch, goarch string
+		want                   bool
+	}{
+		{"linux", "amd64", "amd64", true},
+		{"linux", "amd64", "386", true},
+		{"linux", "amd64", "arm64", false},
+		{"linux", "386", "amd64", false},
+		{"linux", "arm64", "arm64", true},
+		{"linux", "arm64", "arm", false},
+		{"darwin", "amd64", "386", false},
+		{"windows", "amd64", "386", false},
+	}
+	for _, tc := range tests {
+		if got := canExecOn(tc.goos, tc.hostarch, tc.goarch); got != tc.want {
+			t.Errorf("canExecOn(%q, %q, %q) = %v, want %v", tc.goos, tc.hostarch, tc.goarch, got, tc.want)
+		}
+	}
+}
+
+func TestNeedLinknameFlag(t *testing.T) {
+	tests := []struct {
+		v    string
+		want bool
+	}{
+		{"go1.18", false},
+		{"go1.22.3", false},
+		{"go1.23", true},
+		{"go1.23rc1", true},
+		{"go1.27.1", true},
+		{"go1.9", false},
+		{"devel go1.24-1234567 Tue Jan 1 00:00:00 2024 +0000", true},
+		{"", false},
+	}
+	for _, tc := range tests {
+		if got := needLinknameFlag(tc.v); got != tc.want {
+			t.Errorf("needLinknameFlag(%q) = %v, want %v", tc.v, got, tc.want)
+		}
+	}
+}
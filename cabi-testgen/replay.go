@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/thanm/cabi-testgen/generator"
+)
+
+// saveFailure records the run whose output in 'dir' failed to build
+// or run for some of the targets in 'goarchs' as a new entry in the
+// -corpus directory, along with the generated sources.
+func saveFailure(dir string, goarchs []string, failed []archResult) {
+	f, err := os.Open(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		log.Printf("saving corpus entry: %v", err)
+		return
+	}
+	m, err := generator.ReadManifest(f)
+	f.Close()
+	if err != nil {
+		log.Printf("saving corpus entry: %v", err)
+		return
+	}
+	src, err := archiveSources(dir)
+	if err != nil {
+		log.Printf("saving corpus entry: %v", err)
+		return
+	}
+	e := &generator.CorpusEntry{
+		Manifest:  m,
+		Goarchs:   goarchs,
+		Toolchain: toolchainVersion(),
+		Failures:  failureList(failed),
+		Sources:   src,
+	}
+	path, err := e.Save(*corpusflag)
+	if err != nil {
+		log.Printf("saving corpus entry: %v", err)
+		return
+	}
+	verb(0, "failing case saved to %s", path)
+}
+
+// archiveSources returns the generated files in 'dir' (the Go
+// sources, go.mod and the manifest) as a txtar archive.
+func archiveSources(dir string) ([]byte, error) {
+	var b bytes.Buffer
+	a := generator.NewTxtarOutput(&b)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		name := d.Name()
+		if !strings.HasSuffix(name, ".go") && name != "go.mod" && name != "manifest.json" {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		c, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		w, err := a.Create(filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+		w.Write(c)
+		return w.Close()
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := a.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// extractSources writes the files in txtar archive 'src' (as
+// returned by archiveSources) into directory 'dir'.
+func extractSources(src []byte, dir string) error {
+	files, err := generator.ParseTxtar(src)
+	if err != nil {
+		return err
+	}
+	out := generator.NewDirOutput(dir)
+	for n, c := range files {
+		w, err := out.Create(n)
+		if err != nil {
+			return err
+		}
+		w.Write(c)
+		if err := w.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// failureList describes each of the results in 'failed', e.g.
+// "amd64 run".
+func failureList(failed []archResult) []string {
+	fl := []string{}
+	for _, r := range failed {
+		fl = append(fl, r.goarch+" "+r.stage)
+	}
+	return fl
+}
+
+// replayOutcome is the result of replaying a corpus entry.
+type replayOutcome int
+
+const (
+	replayPass replayOutcome = iota // builds and runs cleanly now
+	replayFail                      // still fails
+	replaySkip                      // couldn't be replayed at all
+)
+
+// replayEntry regenerates corpus entry 'e' into a temporary
+// directory, then builds and runs it. It returns a description of the
+// outcome, and the outcome itself. If the manifest can't be turned
+// back into a config (e.g. because it's from another generator
+// version), the saved sources are built and run instead; an entry
+// without any is skipped. The output directory of a failing entry is
+// kept, for further investigation.
+func replayEntry(e *generator.CorpusEntry) (string, replayOutcome) {
+	cfg, tunables, err := e.Manifest.Config()
+	if err != nil && e.Sources == nil {
+		return fmt.Sprintf("SKIP (%v)", err), replaySkip
+	}
+	dir, derr := ioutil.TempDir("", "cabi-replay")
+	if derr != nil {
+		log.Fatal(derr)
+	}
+	tag := cfg.Tag
+	how := ""
+	if err != nil {
+		verb(1, "%s: %v; using saved sources", e.Name, err)
+		if err := extractSources(e.Sources, dir); err != nil {
+			os.RemoveAll(dir)
+			return fmt.Sprintf("SKIP (bad saved sources: %v)", err), replaySkip
+		}
+		tag = e.Manifest.Tag
+		how = "saved sources, "
+	} else {
+		generator.SetTunables(tunables)
+		cfg.OutDir = dir
+		cfg.Parallelism = *parflag
+		verb(1, "replaying %s into %s", e.Name, dir)
+		if generator.GenerateFromConfig(cfg) != 0 {
+			return fmt.Sprintf("FAIL (generate), output in %s", dir), replayFail
+		}
+	}
+	failed := runGenerated(dir, tag, e.Goarchs)
+	if len(failed) != 0 {
+		return fmt.Sprintf("FAIL (%s%s), output in %s", how, strings.Join(failureList(failed), ", "), dir), replayFail
+	}
+	os.RemoveAll(dir)
+	return fmt.Sprintf("PASS (%sfailed with %s: %s)", how, e.Toolchain, strings.Join(e.Failures, ", ")), replayPass
+}
+
+// replay regenerates, builds and runs each of the cases saved in
+// corpus directory 'dir' with the current toolchain, then prints a
+// summary of which ones still fail and which ones couldn't be
+// replayed. Return value is false if any fail or were skipped.
+func replay(dir string) bool {
+	entries, err := generator.ReadCorpus(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(entries) == 0 {
+		log.Fatalf("no corpus entries in %s", dir)
+	}
+	summary := []string{}
+	nfail, nskip := 0, 0
+	for _, e := range entries {
+		status, outcome := replayEntry(e)
+		switch outcome {
+		case replayFail:
+			nfail++
+		case replaySkip:
+			nskip++
+		}
+		summary = append(summary, fmt.Sprintf("%s: %s", e.Name, status))
+	}
+	fmt.Printf("\nreplayed %d corpus entries with %s\n", len(entries), toolchainVersion())
+	for _, s := range summary {
+		fmt.Println(s)
+	}
+	fmt.Printf("%d of %d still fail, %d skipped\n", nfail, len(entries), nskip)
+	return nfail == 0 && nskip == 0
+}
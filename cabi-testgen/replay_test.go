@@ -0,0 +1,80 @@
+package main
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/thanm/cabi-testgen/generator"
+)
+
+func TestReplaySkip(t *testing.T) {
+	dir := t.TempDir()
+	e := &generator.CorpusEntry{
+		Manifest: &generator.Manifest{Version: "0.0", Tag: "stale", Tunables: generator.DefaultTunables()},
+		Goarchs:  []string{"amd64"},
+		Failures: []string{"amd64 run"},
+	}
+	if _, err := e.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+	status, outcome := replayEntry(e)
+	if outcome != replaySkip || !strings.HasPrefix(status, "SKIP") {
+		t.Errorf("replayEntry of stale entry = %q, %d, want SKIP", status, outcome)
+	}
+	if replay(dir) {
+		t.Errorf("replay succeeded with a skipped entry")
+	}
+}
+
+func TestReplaySources(t *testing.T) {
+	dir := t.TempDir()
+	errs := generator.GenerateFromConfig(generator.GenConfig{
+		Tag:              "x",
+		OutDir:           dir,
+		PkgPath:          "replaytest",
+		NumTestFunctions: 3,
+		NumTestPackages:  1,
+		Seed:             7,
+		MaxFail:          10,
+		ForceStackGrowth: true,
+		RandCtl:          generator.RandCtlChecks | generator.RandCtlPanic,
+	})
+	if errs != 0 {
+		t.Fatalf("%d errors during Generate", errs)
+	}
+	src, err := archiveSources(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := generator.ParseTxtar(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []string{"go.mod", "manifest.json", "xMain.go", "xChecker0/xChecker0.go"} {
+		if _, ok := files[n]; !ok {
+			t.Errorf("saved sources missing %s", n)
+		}
+	}
+
+	// The manifest is from another generator version, so the entry
+	// can only be replayed from its sources.
+	cdir := t.TempDir()
+	e := &generator.CorpusEntry{
+		Manifest: &generator.Manifest{Version: "0.0", Tag: "x", Tunables: generator.DefaultTunables()},
+		Goarchs:  []string{runtime.GOARCH},
+		Failures: []string{runtime.GOARCH + " run"},
+		Sources:  src,
+	}
+	if _, err := e.Save(cdir); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := generator.ReadCorpus(cdir)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("ReadCorpus: %d entries, %v", len(entries), err)
+	}
+	status, outcome := replayEntry(entries[0])
+	if outcome != replayPass || !strings.Contains(status, "saved sources") {
+		t.Errorf("replayEntry from sources = %q, %d, want PASS from saved sources", status, outcome)
+	}
+}
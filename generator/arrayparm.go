@@ -13,6 +13,11 @@ type arrayparm struct {
 	nelements uint8
 	eltype    parm
 	slice     bool
+	// for slices with no elements, true if the slice is nil
+	isnil bool
+	// for top-level byte slice params and results, true if the
+	// value is passed via a string -> []byte conversion
+	viaString bool
 	isBlank
 	addrTakenHow
 	isGenValFunc
@@ -52,6 +57,9 @@ func (p arrayparm) GenValue(s *genstate, f *funcdef, value int, caller bool) (st
 	if caller {
 		n = p.qname
 	}
+	if p.isnil {
+		return fmt.Sprintf("%s(nil)", n), value
+	}
 	buf.WriteString(fmt.Sprintf("%s{", n))
 	for i := 0; i < int(p.nelements); i++ {
 		var valstr string
@@ -0,0 +1,112 @@
+package generator
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CorpusEntry records a run of the generator whose output failed to
+// build or run, so that the case can be replayed later on (for
+// example against a newer toolchain, to see whether it has been
+// fixed). The manifest supplies the seed, masks, tunables and other
+// settings; its per-function records are dropped, since Config
+// doesn't need them. Since a manifest can only be regenerated by the
+// generator version that wrote it, the generated sources are saved
+// as well, for replaying the entry once the generator has moved on.
+type CorpusEntry struct {
+	// File name within the corpus directory (not saved).
+	Name string `json:"-"`
+
+	Manifest *Manifest `json:"manifest"`
+
+	// Target architectures built and run, in the form accepted by
+	// the -goarch flag.
+	Goarchs []string `json:"goarchs"`
+
+	// Toolchain in use when the failure was found, as reported by
+	// "go version".
+	Toolchain string `json:"toolchain"`
+
+	// Failures seen, e.g. "amd64 run".
+	Failures []string `json:"failures"`
+
+	// Generated sources as a txtar archive (see NewTxtarOutput and
+	// ParseTxtar), or nil if they weren't saved. They are kept in a
+	// file of their own next to the entry, named after it with
+	// ".txtar" in place of ".json".
+	Sources []byte `json:"-"`
+}
+
+// Save writes 'e' to a new file in corpus directory 'dir' (and its
+// sources, if any, to another), creating the directory if need be,
+// and returns the path of the entry's file. The
+// file name is derived from the seed and the contents, so saving the
+// same failure twice leaves a single entry.
+func (e *CorpusEntry) Save(dir string) (string, error) {
+	m := *e.Manifest
+	m.Funcs = nil
+	ec := *e
+	ec.Manifest = &m
+	data, err := json.MarshalIndent(&ec, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	name := fmt.Sprintf("%s-s%d-%x.json", m.Tag, m.Seed, sum[:4])
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, append(data, '\n'), 0666); err != nil {
+		return "", err
+	}
+	if e.Sources != nil {
+		if err := ioutil.WriteFile(sourcesPath(path), e.Sources, 0666); err != nil {
+			return "", err
+		}
+	}
+	e.Name = name
+	return path, nil
+}
+
+// ReadCorpus reads the entries saved in corpus directory 'dir', in
+// order of file name.
+func ReadCorpus(dir string) ([]*CorpusEntry, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var entries []*CorpusEntry
+	for _, fi := range fis {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".json") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		e := &CorpusEntry{Name: fi.Name(), Manifest: &Manifest{Tunables: DefaultTunables()}}
+		if err := json.Unmarshal(data, e); err != nil {
+			return nil, fmt.Errorf("reading corpus entry %s: %v", fi.Name(), err)
+		}
+		src, err := ioutil.ReadFile(sourcesPath(filepath.Join(dir, fi.Name())))
+		if err == nil {
+			e.Sources = src
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// sourcesPath returns the path of the file holding the sources of
+// the corpus entry saved in 'path'.
+func sourcesPath(path string) string {
+	return strings.TrimSuffix(path, ".json") + ".txtar"
+}
@@ -0,0 +1,234 @@
+package generator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// SpecsFromPackage returns specs (see Specs) for the exported
+// functions and methods of the package with import path 'path',
+// which is loaded from its export data with go/types. The path is
+// resolved by "go list" in the current directory, so it can name a
+// standard library package or any package in the main module or its
+// dependencies. Methods are treated as functions with the receiver
+// prepended to the params, and named types are expanded to their
+// underlying types. Types with no parm counterpart are replaced by
+// ones with the same layout where there is one: bool becomes uint8,
+// channels, funcs and unsafe.Pointer become *uint8, non-empty
+// interfaces become interface{}, and recursive references to a type
+// become uint8 (such references are always behind a pointer, slice or
+// map, so the layout is the same). Signatures that still can't be
+// represented (generic functions, large arrays, pointer map keys and
+// so on) are skipped; 'skipped' describes each of them.
+func SpecsFromPackage(path string) (specs *Specs, skipped []string, err error) {
+	pkg, err := loadExportData(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	sp := &Specs{types: make(map[string]ast.Expr)}
+	var src strings.Builder
+	add := func(name string, recv types.Type, sig *types.Signature) {
+		line, err := sigSpec(recv, sig)
+		if err == nil {
+			err = sp.parseLine(line)
+		}
+		if err != nil {
+			skipped = append(skipped, fmt.Sprintf("%s: %v", name, err))
+			return
+		}
+		fmt.Fprintf(&src, "// %s\n%s\n", name, line)
+	}
+	scope := pkg.Scope()
+	for _, n := range scope.Names() {
+		switch o := scope.Lookup(n).(type) {
+		case *types.Func:
+			if o.Exported() {
+				add(pkg.Name()+"."+n, nil, o.Type().(*types.Signature))
+			}
+		case *types.TypeName:
+			named, ok := o.Type().(*types.Named)
+			if !o.Exported() || o.IsAlias() || !ok {
+				continue
+			}
+			if _, ok := named.Underlying().(*types.Interface); ok {
+				continue
+			}
+			// Only the methods declared on the type itself, not
+			// those promoted from embedded fields.
+			for i := 0; i < named.NumMethods(); i++ {
+				m := named.Method(i)
+				if !m.Exported() {
+					continue
+				}
+				name := fmt.Sprintf("%s.%s.%s", pkg.Name(), n, m.Name())
+				if named.TypeParams().Len() != 0 {
+					skipped = append(skipped, name+": generic receiver type")
+					continue
+				}
+				sig := m.Type().(*types.Signature)
+				add(name, sig.Recv().Type(), sig)
+			}
+		}
+	}
+	if len(sp.funcs) == 0 {
+		return nil, skipped, fmt.Errorf("no usable signatures in package %s", path)
+	}
+	sp.src = src.String()
+	return sp, skipped, nil
+}
+
+// sigSpec returns a spec line (a func type expression) for the
+// signature 'sig', with receiver type 'recv' (if non-nil) as the
+// first param. Variadic params are passed as slices, so they come
+// out that way.
+func sigSpec(recv types.Type, sig *types.Signature) (string, error) {
+	if sig.TypeParams().Len() != 0 {
+		return "", fmt.Errorf("generic function")
+	}
+	var ptypes []types.Type
+	if recv != nil {
+		ptypes = append(ptypes, recv)
+	}
+	for i := 0; i < sig.Params().Len(); i++ {
+		ptypes = append(ptypes, sig.Params().At(i).Type())
+	}
+	tlist := func(tl []types.Type) (string, error) {
+		var ts []string
+		for _, t := range tl {
+			s, err := typeSpec(t, make(map[*types.Named]bool))
+			if err != nil {
+				return "", err
+			}
+			ts = append(ts, s)
+		}
+		return strings.Join(ts, ", "), nil
+	}
+	params, err := tlist(ptypes)
+	if err != nil {
+		return "", err
+	}
+	var rtypes []types.Type
+	for i := 0; i < sig.Results().Len(); i++ {
+		rtypes = append(rtypes, sig.Results().At(i).Type())
+	}
+	results, err := tlist(rtypes)
+	if err != nil {
+		return "", err
+	}
+	if len(rtypes) > 1 {
+		results = "(" + results + ")"
+	}
+	return strings.TrimSpace(fmt.Sprintf("func(%s) %s", params, results)), nil
+}
+
+// typeSpec returns a spec type expression for 't'. 'seen' holds the
+// named types being expanded, so as to detect recursive references.
+func typeSpec(t types.Type, seen map[*types.Named]bool) (string, error) {
+	switch x := t.(type) {
+	case *types.Basic:
+		switch x.Kind() {
+		case types.Bool:
+			return "uint8", nil
+		case types.UnsafePointer:
+			return "*uint8", nil
+		case types.Int, types.Int8, types.Int16, types.Int32, types.Int64,
+			types.Uint, types.Uint8, types.Uint16, types.Uint32, types.Uint64,
+			types.Uintptr, types.Float32, types.Float64,
+			types.Complex64, types.Complex128, types.String:
+			return x.Name(), nil
+		}
+	case *types.Named:
+		if seen[x] {
+			return "uint8", nil
+		}
+		seen[x] = true
+		defer delete(seen, x)
+		return typeSpec(x.Underlying(), seen)
+	case *types.TypeParam:
+		return "", fmt.Errorf("generic type %s", x)
+	case *types.Pointer:
+		s, err := typeSpec(x.Elem(), seen)
+		return "*" + s, err
+	case *types.Slice:
+		s, err := typeSpec(x.Elem(), seen)
+		return "[]" + s, err
+	case *types.Array:
+		s, err := typeSpec(x.Elem(), seen)
+		return fmt.Sprintf("[%d]%s", x.Len(), s), err
+	case *types.Map:
+		k, err := typeSpec(x.Key(), seen)
+		if err != nil {
+			return "", err
+		}
+		v, err := typeSpec(x.Elem(), seen)
+		return "map[" + k + "]" + v, err
+	case *types.Struct:
+		var fl []string
+		for i := 0; i < x.NumFields(); i++ {
+			s, err := typeSpec(x.Field(i).Type(), seen)
+			if err != nil {
+				return "", err
+			}
+			fl = append(fl, x.Field(i).Name()+" "+s)
+		}
+		return "struct{" + strings.Join(fl, "; ") + "}", nil
+	case *types.Interface:
+		return "interface{}", nil
+	case *types.Chan, *types.Signature:
+		return "*uint8", nil
+	default:
+		// e.g. aliases
+		if u := t.Underlying(); u != t {
+			return typeSpec(u, seen)
+		}
+	}
+	return "", fmt.Errorf("unsupported type %s", t)
+}
+
+// loadExportData type-checks the package with import path 'path'
+// from export data. "go list -export" builds the package and its
+// dependencies in the context of the module in the current
+// directory, and reports where the export data for each of them is.
+func loadExportData(path string) (*types.Package, error) {
+	cmd := exec.Command("go", "list", "-export", "-deps", "-json", "--", path)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list %s: %v\n%s", path, err, stderr.String())
+	}
+	exports := make(map[string]string)
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var lp struct {
+			ImportPath string
+			Export     string
+			Error      *struct{ Err string }
+		}
+		if err := dec.Decode(&lp); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("go list %s: %v", path, err)
+		}
+		if lp.Error != nil {
+			return nil, fmt.Errorf("loading %s: %s", lp.ImportPath, lp.Error.Err)
+		}
+		exports[lp.ImportPath] = lp.Export
+	}
+	lookup := func(p string) (io.ReadCloser, error) {
+		if f := exports[p]; f != "" {
+			return os.Open(f)
+		}
+		return nil, fmt.Errorf("no export data for %s", p)
+	}
+	return importer.ForCompiler(token.NewFileSet(), "gc", lookup).Import(path)
+}
@@ -0,0 +1,163 @@
+package generator
+
+import (
+	"flag"
+	"os/exec"
+	"sort"
+	"testing"
+)
+
+var fuzzRunFlag = flag.Bool("fuzzrun", false, "Build and run the code generated by FuzzGenerate, not just type-check it.")
+
+// fuzzTunables decodes the compact tunables encoding used by
+// FuzzGenerate. Starting from 't', each byte of 'data' sets
+// the next knob in the table below (reduced into the valid range for
+// the knob), so short inputs only vary the first few knobs. The
+// distributions take one byte per entry, and are scaled to add up to
+// 100; the bool knobs are set from the bits of a single byte.
+func fuzzTunables(t TunableParams, data []byte) TunableParams {
+	next := func() (byte, bool) {
+		if len(data) == 0 {
+			return 0, false
+		}
+		b := data[0]
+		data = data[1:]
+		return b, true
+	}
+	count := func(p *uint8, n byte) {
+		if b, ok := next(); ok {
+			*p = b % n
+		}
+	}
+	perc := func(p *uint8) {
+		count(p, 101)
+	}
+	dist := func(d []uint8) {
+		var w []int
+		sum := 0
+		for range d {
+			b, ok := next()
+			if !ok {
+				return
+			}
+			w = append(w, int(b))
+			sum += int(b)
+		}
+		if sum == 0 {
+			return
+		}
+		left := 100
+		for i := range d {
+			d[i] = uint8(w[i] * 100 / sum)
+			left -= int(d[i])
+		}
+		for i := 0; left > 0; i++ {
+			if w[i] != 0 {
+				d[i]++
+				left--
+			}
+		}
+	}
+	flags := func(ps ...*bool) {
+		if b, ok := next(); ok {
+			for i, p := range ps {
+				*p = b&(1<<i) != 0
+			}
+		}
+	}
+
+	count(&t.nParmRange, 16)
+	count(&t.nReturnRange, 8)
+	count(&t.structDepth, 4)
+	if b, ok := next(); ok {
+		t.nStructFields = t.structDepth + b%(8-t.structDepth)
+	}
+	if b, ok := next(); ok {
+		t.nArrayElements = 1 + b%5
+	}
+	count(&t.nMapEntries, 7)
+	flags(&t.doReflectCall, &t.takeAddress, &t.doDefer, &t.doGo,
+		&t.doPanic, &t.doMutate, &t.doFuncCallValues, &t.doSkipCompare)
+	perc(&t.recurPerc)
+	perc(&t.methodPerc)
+	perc(&t.pointerMethodCallPerc)
+	perc(&t.regBoundaryPerc)
+	perc(&t.zeroSizePerc)
+	if b, ok := next(); ok {
+		var archs []string
+		for a := range archRegs {
+			archs = append(archs, a)
+		}
+		sort.Strings(archs)
+		t.goarch = archs[int(b)%len(archs)]
+	}
+	dist(t.typeFractions[:])
+	perc(&t.sliceFraction)
+	perc(&t.specialKeyFraction)
+	perc(&t.nilFraction)
+	perc(&t.blankPerc)
+	perc(&t.takenFraction)
+	dist(t.addrFractions[:])
+	perc(&t.deferFraction)
+	perc(&t.goFraction)
+	perc(&t.panicFraction)
+	dist(t.returnStyleFractions[:])
+	perc(&t.mutateFraction)
+	perc(&t.sliceAliasFraction)
+	perc(&t.funcCallValFraction)
+	perc(&t.skipCompareFraction)
+	perc(&t.gcInjectFraction)
+	dist(t.intBitRanges[:])
+	dist(t.floatBitRanges[:])
+	dist(t.unsignedRanges[:])
+	dist(t.stringKindFractions[:])
+	perc(&t.stringViaBytesFraction)
+	return t
+}
+
+// FuzzGenerate generates a package with a single test function, from
+// a seed and a set of tunables encoded as described at fuzzTunables,
+// and type-checks the result. With -fuzzrun, the code is also built
+// and run. For example:
+//
+//	go test -run=XXX -fuzz=FuzzGenerate -fuzzrun
+func FuzzGenerate(f *testing.F) {
+	f.Add(int64(0), []byte{})
+	// minimal, as in TestExhaustive
+	f.Add(int64(9), []byte{3, 3, 1, 6, 4, 3, 0, 0, 0})
+	// methods, register boundary and zero-size functions
+	f.Add(int64(10), []byte{15, 7, 3, 4, 4, 3, 0xff, 20, 60, 50, 50, 50})
+	// lots of maps and strings
+	f.Add(int64(11), []byte{8, 4, 2, 2, 3, 6, 0xff, 0, 0, 0, 0, 0, 0,
+		10, 5, 50, 0, 20, 0, 0, 0, 15, 50, 60, 80})
+
+	saveit := tunables
+	defer func() { tunables = saveit }()
+	f.Fuzz(func(t *testing.T, seed int64, data []byte) {
+		tunables = fuzzTunables(saveit, data)
+		checkTunables(tunables)
+		td := t.TempDir()
+		errs := GenerateFromConfig(GenConfig{
+			Tag:              "x",
+			OutDir:           td,
+			PkgPath:          "fuzz",
+			NumTestFunctions: 1,
+			NumTestPackages:  1,
+			Seed:             seed,
+			MaxFail:          10,
+			RandCtl:          RandCtlChecks | RandCtlPanic,
+			TypeCheck:        true,
+		})
+		if errs != 0 {
+			t.Fatalf("%d errors during Generate with tunables %+v", errs, tunables)
+		}
+		if !*fuzzRunFlag {
+			return
+		}
+		cmd := exec.Command("go", "run", ".")
+		cmd.Dir = td
+		if coutput, cerr := cmd.CombinedOutput(); cerr != nil {
+			t.Fatalf("run failed with tunables %+v: %s", tunables, string(coutput))
+		}
+	})
+}
@@ -1,17 +1,33 @@
 package generator
 
 import (
+	"archive/zip"
 	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
 )
 
 func mkGenState() *genstate {
 	return &genstate{
-		outdir:      "/tmp",
+		out:         NewMemOutput(),
 		ipref:       "foo/",
 		tag:         "gen",
 		numtpk:      1,
@@ -19,6 +35,7 @@ func mkGenState() *genstate {
 		assignFuncs: make(map[string]string),
 		allocFuncs:  make(map[string]string),
 		globVars:    make(map[string]string),
+		genvalFuncs: make(map[string]string),
 	}
 }
 
@@ -67,6 +84,1108 @@ func TestMoreComplicated(t *testing.T) {
 	}
 }
 
+// checkRegBoundary verifies that the register boundary list 'lst'
+// (params or returns, per 'what') of function 'fi' uses exactly the
+// registers it was built to use on architecture 'a': with N registers
+// in the chosen class, either N-1 or N registers with nothing in
+// memory; N+1 registers' worth of scalars, of which only the last
+// winds up in memory; or a struct needing one more register than is
+// left, which goes to memory while the scalar after it is still
+// register-assigned.
+func checkRegBoundary(t *testing.T, fi int, what string, a abiRegs, lst []parm) {
+	t.Helper()
+	ni, nf, spilled := a.assignRegs(lst)
+	n, used := a.ints, ni
+	if nf != 0 {
+		n, used = a.floats, nf
+		if ni != 0 {
+			t.Errorf("func %d: %s use %d int and %d float regs, want one class only", fi, what, ni, nf)
+			return
+		}
+	}
+	regs := func(p parm) int {
+		pi, pf, ok := a.regsFor(p)
+		if !ok {
+			t.Fatalf("func %d: %s: %s can't be register-assigned", fi, what, p.TypeName())
+		}
+		return pi + pf
+	}
+	want := 0
+	for _, p := range lst {
+		want += regs(p)
+	}
+	if len(lst) >= 2 {
+		if sp, ok := lst[len(lst)-2].(*structparm); ok {
+			// struct that needs one more register than is left
+			left := regs(sp) - 1
+			if left < 1 || left > 3 {
+				t.Errorf("func %d: %s: struct needs %d regs, want 2 to 4", fi, what, left+1)
+			}
+			if len(spilled) != 1 || spilled[0] != len(lst)-2 {
+				t.Errorf("func %d: %s in memory: %v, want [%d]", fi, what, spilled, len(lst)-2)
+			}
+			if used != n-left+1 {
+				t.Errorf("func %d: %s use %d regs, want %d", fi, what, used, n-left+1)
+			}
+			return
+		}
+	}
+	switch want {
+	case n - 1, n:
+		if len(spilled) != 0 || used != want {
+			t.Errorf("func %d: %s use %d regs with %v in memory, want %d regs and none in memory", fi, what, used, spilled, want)
+		}
+	case n + 1:
+		last := len(lst) - 1
+		if len(spilled) != 1 || spilled[0] != last {
+			t.Errorf("func %d: %s in memory: %v, want [%d]", fi, what, spilled, last)
+		}
+		if used != want-regs(lst[last]) {
+			t.Errorf("func %d: %s use %d regs, want %d", fi, what, used, want-regs(lst[last]))
+		}
+	default:
+		t.Errorf("func %d: %s need %d regs, want %d, %d or %d", fi, what, want, n-1, n, n+1)
+	}
+}
+
+func TestRegBoundary(t *testing.T) {
+	saveit := tunables
+	defer func() { tunables = saveit }()
+
+	for _, goarch := range []string{"amd64", "arm64", "ppc64le"} {
+		tunables = saveit
+		tunables.goarch = goarch
+		tunables.regBoundaryPerc = 100
+		tunables.zeroSizePerc = 0
+		checkTunables(tunables)
+		a := archRegs[tunables.goarch]
+		s := mkGenState()
+		s.tunables = tunables
+		nrets := 0
+		for i := 0; i < 1000; i++ {
+			s.wr = NewWrapRand(int64(i), RandCtlChecks|RandCtlPanic)
+			fp := s.GenFunc(i, i)
+			if !fp.regboundary {
+				t.Fatalf("%s func %d: not a register boundary function", goarch, i)
+			}
+			checkRegBoundary(t, i, goarch+" params", s.paramRegs(), fp.params)
+			if fp.rbreturns {
+				checkRegBoundary(t, i, goarch+" returns", a, fp.returns)
+				nrets++
+			}
+		}
+		if nrets == 0 {
+			t.Errorf("%s: no functions with register boundary returns", goarch)
+		}
+	}
+}
+
+func TestRegBoundaryNoRegABI(t *testing.T) {
+	tp := DefaultTunables()
+	if err := tp.SetGoarch("386"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tp.EnableRegBoundary(20); !errors.Is(err, ErrNoRegABI) {
+		t.Errorf("EnableRegBoundary(20) for 386 returned %v, want ErrNoRegABI", err)
+	}
+	if err := tp.EnableRegBoundary(0); err != nil {
+		t.Errorf("EnableRegBoundary(0) for 386 returned %v", err)
+	}
+	if err := tp.SetGoarch("amd64"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tp.EnableRegBoundary(20); err != nil {
+		t.Errorf("EnableRegBoundary(20) for amd64 returned %v", err)
+	}
+}
+
+func TestZeroSize(t *testing.T) {
+	saveit := tunables
+	defer func() { tunables = saveit }()
+
+	tunables.zeroSizePerc = 100
+	tunables.methodPerc = 50
+	tunables.regBoundaryPerc = 30
+	tunables.goarch = "amd64"
+	checkTunables(tunables)
+	s := mkGenState()
+	s.tunables = tunables
+	for i := 0; i < 1000; i++ {
+		s.wr = NewWrapRand(int64(i), RandCtlChecks|RandCtlPanic)
+		fp := s.GenFunc(i, i)
+		s.stats.noteZeroSize(fp)
+		var buf bytes.Buffer
+		wr := NewWrapRand(int64(i), RandCtlChecks|RandCtlPanic)
+		s.wr = wr
+		s.emitCaller(fp, &buf, i)
+		s.wr = NewWrapRand(int64(i), RandCtlChecks|RandCtlPanic)
+		s.emitChecker(fp, &buf, i, true)
+		wr.Check(s.wr)
+	}
+	st := s.stats
+	if st.ZeroSizeFuncs != 1000 {
+		t.Errorf("got %d zero-size funcs, want 1000", st.ZeroSizeFuncs)
+	}
+	for _, n := range []int{st.ZeroSizeFirst, st.ZeroSizeLast,
+		st.ZeroSizeBetween, st.ZeroSizeReceiver, st.ZeroSizePointer,
+		st.ZeroSizeMapValue, st.ZeroSizeReturn} {
+		if n == 0 {
+			t.Errorf("zero-size position not covered: %s", st)
+			break
+		}
+	}
+	if s.errs != 0 {
+		t.Errorf("%d errors during Generate", s.errs)
+	}
+}
+
+func TestDeferMutate(t *testing.T) {
+	saveit := tunables
+	defer func() { tunables = saveit }()
+
+	tunables.mutateFraction = 100
+	tunables.deferFraction = 100
+	tunables.recurPerc = 0
+	checkTunables(tunables)
+	s := mkGenState()
+	s.tunables = tunables
+	nmut := 0
+	for i := 0; i < 200; i++ {
+		s.wr = NewWrapRand(int64(i), RandCtlChecks|RandCtlPanic)
+		fp := s.GenFunc(i, i)
+		var buf bytes.Buffer
+		s.wr = NewWrapRand(int64(i), RandCtlChecks|RandCtlPanic)
+		s.emitCaller(fp, &buf, i)
+		buf.Reset()
+		s.wr = NewWrapRand(int64(i), RandCtlChecks|RandCtlPanic)
+		s.emitChecker(fp, &buf, i, true)
+		if !fp.mutates() {
+			continue
+		}
+		nmut++
+		// The deferred checks run after the writes through params,
+		// so they have to leave the mutated params alone.
+		code := buf.String()
+		di := strings.Index(code, "  defer func(")
+		if di == -1 {
+			t.Fatalf("func %d: no defer checks in mutating function:\n%s", i, code)
+		}
+		deferred := code[di:]
+		deferred = deferred[:strings.Index(deferred, "\n  } (")]
+		for pi, m := range fp.mutp {
+			if m && regexp.MustCompile(fmt.Sprintf(`\bp%d\b`, pi)).MatchString(deferred) {
+				t.Errorf("func %d: deferred checks refer to mutated param p%d:\n%s", i, pi, deferred)
+			}
+		}
+	}
+	if nmut == 0 {
+		t.Errorf("no mutating functions generated")
+	}
+}
+
+func TestSpecialKeyInterface(t *testing.T) {
+	s := mkGenState()
+	keytmps := []string{}
+	for e := 0; e < 12; e++ {
+		keytmps = append(keytmps, fmt.Sprintf("mk%d", e))
+	}
+	firsts := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		s.wr = NewWrapRand(int64(i), RandCtlChecks|RandCtlPanic)
+		fixes := s.specialKeyFixups(specialKeyInterface, keytmps)
+		lits := []string{}
+		for e, fx := range fixes {
+			if len(fx) != 1 {
+				t.Fatalf("seed %d key %d: got fixups %q, want one", i, e, fx)
+			}
+			lits = append(lits, strings.TrimPrefix(fx[0], keytmps[e]+" = "))
+		}
+		if lits[0] != lits[1] {
+			t.Errorf("seed %d: keys 0 and 1 differ: %s vs %s", i, lits[0], lits[1])
+		}
+		seen := make(map[string]int)
+		for e, l := range lits[1:] {
+			if pe, ok := seen[l]; ok {
+				t.Errorf("seed %d: keys %d and %d collide: %s", i, pe+1, e+1, l)
+			}
+			seen[l] = e
+		}
+		dt := strings.SplitN(lits[0], "(", 2)[0]
+		if strings.HasPrefix(dt, "\"") {
+			dt = "string"
+		}
+		firsts[dt] = true
+	}
+	// Every dynamic type should show up for the colliding keys.
+	if len(firsts) != len(dynTypes) {
+		t.Errorf("keys 0 and 1 only used %d of %d dynamic types: %v", len(firsts), len(dynTypes), firsts)
+	}
+}
+
+func TestStringConversions(t *testing.T) {
+	saveit := tunables
+	defer func() { tunables = saveit }()
+
+	tunables.stringViaBytesFraction = 100
+	checkTunables(tunables)
+	s := mkGenState()
+	s.tunables = tunables
+	// conversions seen on params and results, in each direction
+	var seen [2][2]int
+	for i := 0; i < 1000; i++ {
+		s.wr = NewWrapRand(int64(i), RandCtlChecks|RandCtlPanic)
+		fp := s.GenFunc(i, i)
+		for k, lst := range [][]parm{fp.params, fp.returns} {
+			for pi, p := range lst {
+				switch {
+				case isViaBytes(p):
+					seen[k][0]++
+				case isViaString(p):
+					seen[k][1]++
+					if k == 0 && (fp.alias.member(pi) != nil || (fp.mutp != nil && fp.mutp[pi])) {
+						t.Errorf("func %d: p%d is converted, but the checker relies on its identity", i, pi)
+					}
+				case isByteSlice(p) && !p.(*arrayparm).isnil && k == 1:
+					t.Errorf("func %d: byte slice r%d not converted", i, pi)
+				}
+			}
+		}
+	}
+	for k, what := range []string{"params", "results"} {
+		if seen[k][0] == 0 || seen[k][1] == 0 {
+			t.Errorf("%s: %d []byte -> string and %d string -> []byte conversions, want some of each", what, seen[k][0], seen[k][1])
+		}
+	}
+}
+
+func TestWrapRandStable(t *testing.T) {
+	// These values are what the global math/rand functions returned
+	// after rand.Seed(12345); they must not change, since they
+	// determine the code generated for a given seed.
+	want := []int{83, 943, 584, 236, 841, 675, 542, 906}
+	w := NewWrapRand(12345, RandCtlChecks)
+	rand.Seed(1)
+	got := []int{}
+	for range want {
+		got = append(got, w.Intn(1000))
+		rand.Intn(1000)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Intn sequence: got %v want %v", got, want)
+	}
+	if f := w.Float32(); f != 0.1336197 {
+		t.Errorf("Float32: got %v want 0.1336197", f)
+	}
+	if f := w.NormFloat64(); f != -1.6605882063507638 {
+		t.Errorf("NormFloat64: got %v want -1.6605882063507638", f)
+	}
+}
+
+// readTree returns the contents of all files under 'dir', keyed by
+// path relative to 'dir'.
+func readTree(t *testing.T, dir string) map[string]string {
+	files := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files[rel] = string(content)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("reading %s: %v", dir, err)
+	}
+	return files
+}
+
+func TestGenerateReentrant(t *testing.T) {
+	td, err := ioutil.TempDir("", "cabi-testgen")
+	if err != nil {
+		t.Fatalf("can't create temp dir")
+	}
+	defer os.RemoveAll(td)
+
+	checkTunables(tunables)
+	gen := func(sub string) {
+		errs := GenerateFromConfig(GenConfig{
+			Tag:              "x",
+			OutDir:           filepath.Join(td, sub),
+			PkgPath:          "foo",
+			NumTestFunctions: 10,
+			NumTestPackages:  3,
+			Seed:             int64(4321),
+			MaxFail:          10,
+			RandCtl:          RandCtlChecks | RandCtlPanic,
+		})
+		if errs != 0 {
+			t.Errorf("%d errors during Generate into %s", errs, sub)
+		}
+	}
+
+	// Generate once on its own, then twice concurrently while
+	// something else uses the global math/rand source; all three
+	// runs should produce the same code.
+	gen("serial")
+	var wg sync.WaitGroup
+	done := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				rand.Seed(rand.Int63())
+			}
+		}
+	}()
+	for _, sub := range []string{"c1", "c2"} {
+		wg.Add(1)
+		go func(sub string) {
+			defer wg.Done()
+			gen(sub)
+		}(sub)
+	}
+	wg.Wait()
+	close(done)
+
+	want := readTree(t, filepath.Join(td, "serial"))
+	for _, sub := range []string{"c1", "c2"} {
+		if got := readTree(t, filepath.Join(td, sub)); !reflect.DeepEqual(got, want) {
+			t.Errorf("output in %s differs from serial output", sub)
+		}
+	}
+}
+
+func TestParallelGenerate(t *testing.T) {
+	td, err := ioutil.TempDir("", "cabi-testgen")
+	if err != nil {
+		t.Fatalf("can't create temp dir")
+	}
+	defer os.RemoveAll(td)
+
+	saveit := tunables
+	defer func() { tunables = saveit }()
+	tunables.zeroSizePerc = 30
+	checkTunables(tunables)
+
+	var stats [2]GenStats
+	for i, par := range []int{1, 4} {
+		errs := GenerateFromConfig(GenConfig{
+			Tag:              "x",
+			OutDir:           filepath.Join(td, fmt.Sprintf("j%d", par)),
+			PkgPath:          "foo",
+			NumTestFunctions: 10,
+			NumTestPackages:  7,
+			Seed:             int64(99),
+			MaxFail:          10,
+			RandCtl:          RandCtlChecks | RandCtlPanic,
+			Stats:            &stats[i],
+			Parallelism:      par,
+		})
+		if errs != 0 {
+			t.Errorf("%d errors during Generate with parallelism %d", errs, par)
+		}
+	}
+	if !reflect.DeepEqual(readTree(t, filepath.Join(td, "j1")), readTree(t, filepath.Join(td, "j4"))) {
+		t.Errorf("parallel output differs from serial output")
+	}
+	if stats[0] != stats[1] {
+		t.Errorf("parallel stats %s differ from serial stats %s", stats[1], stats[0])
+	}
+}
+
+// TestGenerateCompat checks that the positional Generate wrapper
+// emits the same code as GenerateFromConfig.
+func TestGenerateCompat(t *testing.T) {
+	td, err := ioutil.TempDir("", "cabi-testgen")
+	if err != nil {
+		t.Fatalf("can't create temp dir")
+	}
+	defer os.RemoveAll(td)
+
+	old := filepath.Join(td, "old")
+	if errs := Generate("x", old, "foo", 5, 2, 42, "", nil, nil, false, 10, true, RandCtlChecks|RandCtlPanic, false); errs != 0 {
+		t.Fatalf("%d errors during Generate", errs)
+	}
+	cfg := filepath.Join(td, "cfg")
+	errs := GenerateFromConfig(GenConfig{
+		Tag:              "x",
+		OutDir:           cfg,
+		PkgPath:          "foo",
+		NumTestFunctions: 5,
+		NumTestPackages:  2,
+		Seed:             42,
+		MaxFail:          10,
+		ForceStackGrowth: true,
+		RandCtl:          RandCtlChecks | RandCtlPanic,
+	})
+	if errs != 0 {
+		t.Fatalf("%d errors during GenerateFromConfig", errs)
+	}
+	if !reflect.DeepEqual(readTree(t, old), readTree(t, cfg)) {
+		t.Errorf("Generate output differs from GenerateFromConfig output")
+	}
+}
+
+func TestOutputBackends(t *testing.T) {
+	td, err := ioutil.TempDir("", "cabi-testgen")
+	if err != nil {
+		t.Fatalf("can't create temp dir")
+	}
+	defer os.RemoveAll(td)
+
+	checkTunables(tunables)
+	gen := func(outdir string, out Output) {
+		errs := GenerateFromConfig(GenConfig{
+			Tag:              "x",
+			OutDir:           outdir,
+			Output:           out,
+			PkgPath:          "foo",
+			NumTestFunctions: 5,
+			NumTestPackages:  3,
+			Seed:             int64(55),
+			MaxFail:          10,
+			RandCtl:          RandCtlChecks | RandCtlPanic,
+			Parallelism:      3,
+		})
+		if errs != 0 {
+			t.Fatalf("%d errors during Generate", errs)
+		}
+	}
+	gen(td, nil)
+	want := readTree(t, td)
+
+	mem := NewMemOutput()
+	gen("", mem)
+	got := make(map[string]string)
+	for n, c := range mem.Files() {
+		got[filepath.FromSlash(n)] = string(c)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("in-memory output differs from directory output")
+	}
+	got = make(map[string]string)
+	err = fs.WalkDir(mem.FS(), ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		c, err := fs.ReadFile(mem.FS(), path)
+		got[filepath.FromSlash(path)] = string(c)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("walking in-memory output: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("in-memory output via FS differs from directory output")
+	}
+	if err := fstest.TestFS(mem.FS(), "go.mod", "xMain.go", "xUtils/xUtils.go"); err != nil {
+		t.Errorf("in-memory output FS: %v", err)
+	}
+
+	var tb bytes.Buffer
+	txtar := NewTxtarOutput(&tb)
+	gen("", txtar)
+	if err := txtar.Close(); err != nil {
+		t.Fatalf("writing txtar: %v", err)
+	}
+	tfiles, err := ParseTxtar(tb.Bytes())
+	if err != nil {
+		t.Fatalf("reading txtar: %v", err)
+	}
+	got = make(map[string]string)
+	for n, c := range tfiles {
+		got[filepath.FromSlash(n)] = string(c)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("txtar output differs from directory output")
+	}
+
+	var zb bytes.Buffer
+	za := NewZipOutput(&zb)
+	gen("", za)
+	if err := za.Close(); err != nil {
+		t.Fatalf("writing zip: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(zb.Bytes()), int64(zb.Len()))
+	if err != nil {
+		t.Fatalf("reading zip: %v", err)
+	}
+	got = make(map[string]string)
+	for _, zf := range zr.File {
+		rc, err := zf.Open()
+		if err != nil {
+			t.Fatalf("reading zip: %v", err)
+		}
+		c, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		got[filepath.FromSlash(zf.Name)] = string(c)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("zip output differs from directory output")
+	}
+}
+
+func TestMalformedOutput(t *testing.T) {
+	gf := &genFile{name: "x.go"}
+	gf.Write([]byte("package x\n\n"))
+	var b bytes.Buffer
+	b.WriteString("func F0() {\n}\n\n")
+	gf.writeFunc(&b, 0, 100)
+	b.WriteString("func F1() {\n  x := \n}\n\n")
+	gf.writeFunc(&b, 1, 101)
+	b.WriteString("func F2() {\n}\n")
+	gf.writeFunc(&b, 2, 102)
+	if _, nerrs := gf.format(); nerrs == 0 {
+		t.Fatalf("malformed output not detected")
+	}
+	for _, tc := range []struct {
+		line int
+		fidx int
+	}{{3, 0}, {7, 1}, {10, 2}} {
+		if r := gf.lineRegion(tc.line); r == nil || r.fidx != tc.fidx || r.seed != int64(100+tc.fidx) {
+			t.Errorf("line %d: got region %+v, want function %d", tc.line, r, tc.fidx)
+		}
+	}
+	if r := gf.lineRegion(1); r != nil {
+		t.Errorf("line 1: got region %+v, want none", r)
+	}
+}
+
+func TestTypeCheck(t *testing.T) {
+	mkfile := func(name string, hdr string, funcs ...string) *genFile {
+		gf := &genFile{name: name}
+		gf.Write([]byte(hdr))
+		for i, fn := range funcs {
+			var b bytes.Buffer
+			b.WriteString(fn)
+			gf.writeFunc(&b, i, int64(1000+i))
+		}
+		src, nerrs := gf.format()
+		if nerrs != 0 {
+			t.Fatalf("%s doesn't parse", name)
+		}
+		gf.src = src
+		return gf
+	}
+	utils := mkfile("xUtils/xUtils.go", "package xUtils\n\n",
+		"func U() int { return 1 }\n\n")
+	mkchecker := func(rt string) *genFile {
+		return mkfile("xChecker0/xChecker0.go",
+			"package xChecker0\n\nimport \"m/xUtils\"\nimport \"fmt\"\n\n",
+			"func Test0() int  {\n  return xUtils.U()\n}\n\n",
+			"type T1 struct{ F0 int }\n\nfunc Test1()   "+rt+" {\n  fmt.Println()\n  return T1{}.F0\n}\n\n",
+			"func Test2() {\n}\n")
+	}
+	main := mkfile("xMain.go", "package main\n\nimport \"m/xChecker0\"\n\n",
+		"func main() { xChecker0.Test2() }\n")
+
+	if errs := typeCheck([]*genFile{utils, mkchecker("int"), main}, "m/", "m"); len(errs) != 0 {
+		t.Errorf("unexpected type errors: %v", errs)
+	}
+	// Make Test1 return the wrong type.
+	errs := typeCheck([]*genFile{utils, mkchecker("string"), main}, "m/", "m")
+	if len(errs) != 1 || !strings.Contains(errs[0], "(package xChecker0, function 1, seed 1001)") {
+		t.Errorf("got type errors %q, want one error in function 1", errs)
+	}
+}
+
+func TestManifest(t *testing.T) {
+	tp := DefaultTunables()
+	if n := reflect.TypeOf(tp).NumField(); n != len(tp.fields()) {
+		t.Fatalf("tunable field table has %d entries, want %d", len(tp.fields()), n)
+	}
+	tp.DisableGo()
+	tp.EnableZeroSize(30)
+	tp.SetGoarch("arm64")
+	data, err := json.Marshal(tp)
+	if err != nil {
+		t.Fatalf("marshaling tunables: %v", err)
+	}
+	var rt TunableParams
+	if err := json.Unmarshal(data, &rt); err != nil {
+		t.Fatalf("unmarshaling tunables: %v", err)
+	}
+	if !reflect.DeepEqual(tp, rt) {
+		t.Errorf("tunables don't survive a round trip: got %+v want %+v", rt, tp)
+	}
+
+	checkTunables(tunables)
+	gen := func(c GenConfig) *Manifest {
+		mem := NewMemOutput()
+		c.Output = mem
+		c.MaxFail = 10
+		c.RandCtl = RandCtlChecks | RandCtlPanic
+		if errs := GenerateFromConfig(c); errs != 0 {
+			t.Fatalf("%d errors during Generate", errs)
+		}
+		m, err := ReadManifest(bytes.NewReader(mem.Files()["manifest.json"]))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		return m
+	}
+	m := gen(GenConfig{Tag: "x", PkgPath: "foo", NumTestFunctions: 4, NumTestPackages: 2, Seed: 66})
+	if len(m.Funcs) != 8 || m.Version != Version || m.Tunables != tunables {
+		t.Fatalf("bad manifest %+v", m)
+	}
+	for _, mf := range m.Funcs {
+		if mf.Seed != funcSeed(66, mf.Pkg, mf.Fn) {
+			t.Errorf("%s: seed %d, want %d", mf.Name, mf.Seed, funcSeed(66, mf.Pkg, mf.Fn))
+		}
+	}
+
+	// Functions don't depend on how many others are generated.
+	bigger := gen(GenConfig{Tag: "x", PkgPath: "foo", NumTestFunctions: 7, NumTestPackages: 3, Seed: 66})
+	for _, mf := range m.Funcs {
+		bf := bigger.Funcs[mf.Pkg*7+mf.Fn]
+		if bf != mf {
+			t.Errorf("got %+v with more functions, want %+v", bf, mf)
+		}
+	}
+
+	// Regenerate a single function.
+	rc, rt, err := m.RegenConfig(1, 2)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if rt != tunables {
+		t.Errorf("regen tunables differ")
+	}
+	rm := gen(rc)
+	if len(rm.Funcs) != 1 || rm.Funcs[0] != m.Funcs[6] {
+		t.Errorf("regenerated %+v, want %+v", rm.Funcs, m.Funcs[6])
+	}
+	if _, _, err := m.RegenConfig(2, 0); err == nil {
+		t.Errorf("regenerating missing function succeeded")
+	}
+}
+
+// goldenVersion and goldenHash record the hash of the code that
+// Generate emits for the fixed config in TestVersionGolden, as of
+// generator version goldenVersion.
+const (
+	goldenVersion = "0.8"
+	goldenHash    = "7e094eaed79dc7910b8a5c3680f9cfecd0cf5ec165d6d4f20d98deb6d7a1c49f"
+)
+
+// TestVersionGolden checks that the generated code only changes when
+// Version does, since -regen and corpus replay rely on a manifest
+// regenerating exactly what it describes.
+func TestVersionGolden(t *testing.T) {
+	saveit := tunables
+	defer func() { tunables = saveit }()
+
+	if err := tunables.SetGoarch("amd64"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	tunables.regBoundaryPerc = 20
+	tunables.gcInjectFraction = 10
+	tunables.zeroSizePerc = 20
+	checkTunables(tunables)
+	mem := NewMemOutput()
+	errs := GenerateFromConfig(GenConfig{
+		Tag:              "x",
+		Output:           mem,
+		PkgPath:          "golden",
+		NumTestFunctions: 30,
+		NumTestPackages:  2,
+		Seed:             12345,
+		MaxFail:          10,
+		RandCtl:          RandCtlChecks | RandCtlPanic,
+		ForceStackGrowth: true,
+	})
+	if errs != 0 {
+		t.Fatalf("%d errors during Generate", errs)
+	}
+	files := mem.Files()
+	names := make([]string, 0, len(files))
+	for n := range files {
+		if n != "manifest.json" {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, n := range names {
+		fmt.Fprintf(h, "%s %d\n", n, len(files[n]))
+		h.Write(files[n])
+	}
+	sum := fmt.Sprintf("%x", h.Sum(nil))
+	if Version != goldenVersion {
+		t.Fatalf("Version is %s but the golden hash is for %s: set goldenVersion = %q and goldenHash = %q", Version, goldenVersion, Version, sum)
+	}
+	if sum != goldenHash {
+		t.Errorf("generated code changed (hash %s, want %s): bump Version, then update goldenVersion and goldenHash", sum, goldenHash)
+	}
+}
+
+func TestCorpus(t *testing.T) {
+	checkTunables(tunables)
+	gen := func(c GenConfig) map[string][]byte {
+		mem := NewMemOutput()
+		c.Output = mem
+		if errs := GenerateFromConfig(c); errs != 0 {
+			t.Fatalf("%d errors during Generate", errs)
+		}
+		return mem.Files()
+	}
+	files := gen(GenConfig{
+		Tag:              "x",
+		PkgPath:          "foo",
+		NumTestFunctions: 5,
+		NumTestPackages:  3,
+		Seed:             55,
+		FcnMask:          map[int]int{1: 1, 3: 1},
+		PkgMask:          map[int]int{2: 1},
+		MaxFail:          10,
+		RandCtl:          RandCtlChecks | RandCtlPanic,
+	})
+	m, err := ReadManifest(bytes.NewReader(files["manifest.json"]))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	td, err := ioutil.TempDir("", "cabi-testgen")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(td)
+	var src bytes.Buffer
+	ta := NewTxtarOutput(&src)
+	for n, c := range files {
+		w, _ := ta.Create(n)
+		w.Write(c)
+		w.Close()
+	}
+	if err := ta.Close(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	e := &CorpusEntry{
+		Manifest:  m,
+		Goarchs:   []string{"amd64", "386"},
+		Toolchain: "go version devel",
+		Failures:  []string{"386 run"},
+		Sources:   src.Bytes(),
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := e.Save(td); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	entries, err := ReadCorpus(td)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d corpus entries, want 1", len(entries))
+	}
+	re := entries[0]
+	if re.Name != e.Name || re.Toolchain != e.Toolchain || len(re.Manifest.Funcs) != 0 ||
+		!reflect.DeepEqual(re.Goarchs, e.Goarchs) || !reflect.DeepEqual(re.Failures, e.Failures) {
+		t.Fatalf("got corpus entry %+v, want %+v", re, e)
+	}
+	if sf, err := ParseTxtar(re.Sources); err != nil || !reflect.DeepEqual(sf, files) {
+		t.Errorf("corpus entry sources don't match original output (err %v)", err)
+	}
+
+	// Replaying the entry reproduces the original output.
+	c, rt, err := re.Manifest.Config()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if rt != tunables {
+		t.Errorf("corpus tunables differ")
+	}
+	if !reflect.DeepEqual(gen(c), files) {
+		t.Errorf("replayed corpus entry doesn't match original output")
+	}
+}
+
+func TestSpecs(t *testing.T) {
+	for _, tc := range []struct {
+		src string
+		err string
+	}{
+		{"func(...int8)", "variadic"},
+		{"func(chan int8)", "unsupported type chan"},
+		{"func(bool)", "unknown or unsupported type bool"},
+		{"func(map[[]int8]int8)", "invalid map key type"},
+		{"func(map[*int8]int8)", "pointer map keys"},
+		{"func([300]int8)", "out of range"},
+		{"type T struct{ p *T }", "recursive type T"},
+		{"int8", "not a func type"},
+		{"// nothing here", "no function signatures"},
+	} {
+		_, err := ParseSpecs("x.spec", []byte(tc.src))
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%q: got error %v, want %q", tc.src, err, tc.err)
+		}
+	}
+
+	src := `// test specs
+type Pair struct{ A int8; _ float32 }
+func(int8, struct{float32; Z [0]int64}, Pair) (Pair, string)
+func(_ uint16, p *Pair, m map[Pair][]byte, x interface{}) [3]complex64
+
+func()
+`
+	specs, err := ParseSpecs("x.spec", []byte(src))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	checkTunables(tunables)
+	mem := NewMemOutput()
+	errs := GenerateFromConfig(GenConfig{
+		Tag:             "x",
+		Output:          mem,
+		PkgPath:         "foo",
+		NumTestPackages: 2,
+		Seed:            77,
+		MaxFail:         10,
+		RandCtl:         RandCtlChecks | RandCtlPanic,
+		TypeCheck:       true,
+		Specs:           specs,
+	})
+	if errs != 0 {
+		t.Fatalf("%d errors during Generate", errs)
+	}
+	m, err := ReadManifest(bytes.NewReader(mem.Files()["manifest.json"]))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := []string{
+		"func(int8, struct{F0 float32; F1 [0]int64}, struct{F0 int8; _ float32}) (struct{F0 int8; _ float32}, string)",
+		"func(uint16, *struct{F0 int8; _ float32}, map[struct{F0 int8; _ float32}][]byte, interface{}) [3]complex64",
+		"func()",
+	}
+	if len(m.Funcs) != 2*len(want) || m.Specs != src {
+		t.Fatalf("bad manifest %+v", m)
+	}
+	for i, mf := range m.Funcs {
+		if mf.Signature != want[mf.Fn] || mf.Fn != i%len(want) {
+			t.Errorf("%s: got signature %q, want %q", mf.Name, mf.Signature, want[mf.Fn])
+		}
+	}
+	checkSpecDecls(t, mem.Files(), "x", 2, specs)
+}
+
+// checkSpecDecls checks that each TestN in the checker files (from
+// 'files', with tag 'tag', for 'npkgs' packages) is declared with
+// exactly the signature of spec N: no params or results added or
+// removed, and each one of the same type, once the types declared in
+// the checker file and the spec are expanded. Field names other than
+// "_" aren't compared, since only the layout of a spec is preserved.
+func checkSpecDecls(t *testing.T, files map[string][]byte, tag string, npkgs int, specs *Specs) {
+	t.Helper()
+	ptrBits := 8 * archRegs[tunables.goarch].ptrSize
+	for k := 0; k < npkgs; k++ {
+		name := fmt.Sprintf("%sChecker%d/%sChecker%d.go", tag, k, tag, k)
+		f, err := parser.ParseFile(token.NewFileSet(), name, files[name], 0)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		defs := make(map[string]ast.Expr)
+		decls := make(map[string]*ast.FuncType)
+		for _, d := range f.Decls {
+			switch d := d.(type) {
+			case *ast.GenDecl:
+				for _, sp := range d.Specs {
+					if ts, ok := sp.(*ast.TypeSpec); ok {
+						defs[ts.Name.Name] = ts.Type
+					}
+				}
+			case *ast.FuncDecl:
+				if d.Recv == nil && strings.HasPrefix(d.Name.Name, "Test") {
+					decls[d.Name.Name] = d.Type
+				}
+			}
+		}
+		for i, ft := range specs.funcs {
+			fn := fmt.Sprintf("Test%d", i)
+			decl, ok := decls[fn]
+			if !ok {
+				t.Errorf("%s: no declaration of %s", name, fn)
+				continue
+			}
+			got := specCanon(decl, defs, ptrBits)
+			want := specCanon(ft, specs.types, ptrBits)
+			if got != want {
+				t.Errorf("%s: %s declared as %s, want %s", name, fn, got, want)
+			}
+		}
+	}
+}
+
+// specCanon returns a canonical form of type expression 'e', with
+// the names in 'defs' expanded, predeclared aliases and
+// target-dependent types replaced by what they stand for, and param
+// and (non-blank) field names dropped.
+func specCanon(e ast.Expr, defs map[string]ast.Expr, ptrBits int) string {
+	list := func(fl *ast.FieldList, sep string, keepBlank bool) string {
+		var parts []string
+		if fl == nil {
+			return ""
+		}
+		for _, fld := range fl.List {
+			ty := specCanon(fld.Type, defs, ptrBits)
+			if len(fld.Names) == 0 {
+				parts = append(parts, ty)
+			}
+			for _, n := range fld.Names {
+				if keepBlank && n.Name == "_" {
+					parts = append(parts, "_ "+ty)
+				} else {
+					parts = append(parts, ty)
+				}
+			}
+		}
+		return strings.Join(parts, sep)
+	}
+	switch x := e.(type) {
+	case *ast.Ident:
+		if d, ok := defs[x.Name]; ok {
+			return specCanon(d, defs, ptrBits)
+		}
+		switch x.Name {
+		case "byte":
+			return "uint8"
+		case "rune":
+			return "int32"
+		case "any":
+			return "interface{}"
+		case "int":
+			return fmt.Sprintf("int%d", ptrBits)
+		case "uint", "uintptr":
+			return fmt.Sprintf("uint%d", ptrBits)
+		}
+		return x.Name
+	case *ast.ParenExpr:
+		return specCanon(x.X, defs, ptrBits)
+	case *ast.StarExpr:
+		return "*" + specCanon(x.X, defs, ptrBits)
+	case *ast.ArrayType:
+		n := ""
+		if x.Len != nil {
+			n = x.Len.(*ast.BasicLit).Value
+		}
+		return "[" + n + "]" + specCanon(x.Elt, defs, ptrBits)
+	case *ast.MapType:
+		return "map[" + specCanon(x.Key, defs, ptrBits) + "]" + specCanon(x.Value, defs, ptrBits)
+	case *ast.StructType:
+		return "struct{" + list(x.Fields, "; ", true) + "}"
+	case *ast.SelectorExpr:
+		return specCanon(x.X, defs, ptrBits) + "." + x.Sel.Name
+	case *ast.InterfaceType:
+		return "interface{}"
+	case *ast.FuncType:
+		return "func(" + list(x.Params, ", ", false) + ") (" + list(x.Results, ", ", false) + ")"
+	}
+	return fmt.Sprintf("<%T>", e)
+}
+
+func TestSpecsFromPackage(t *testing.T) {
+	const fixture = "github.com/thanm/cabi-testgen/generator/testdata/"
+	specs, skipped, err := SpecsFromPackage(fixture + "frompkg")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := `// frompkg.Cut
+func(string, string) (string, string, uint8)
+// frompkg.Pair.Set
+func(*struct{A int8; b float32}, int8, *uint8) interface{}
+// frompkg.Pair.Sum
+func(struct{A int8; b float32}) float64
+// frompkg.Repeat
+func(string, int) string
+// frompkg.Show
+func(interface{}, map[string]uintptr) [2]complex64
+// frompkg.Walk
+func(*struct{Next *uint8; V int32}, *uint8) *uint8
+`
+	if specs.src != want {
+		t.Errorf("got specs\n%s\nwant\n%s", specs.src, want)
+	}
+	wantSkipped := []string{
+		"frompkg.Big: array length 1000 out of range",
+		"frompkg.ByPtr: pointer map keys not supported",
+		"frompkg.Ident: generic function",
+	}
+	if !reflect.DeepEqual(skipped, wantSkipped) {
+		t.Errorf("got skipped %q, want %q", skipped, wantSkipped)
+	}
+	if _, err := ParseSpecs("x.spec", []byte(specs.src)); err != nil {
+		t.Errorf("reparsing specs: %v", err)
+	}
+
+	// The test functions have exactly the imported signatures.
+	checkTunables(tunables)
+	mem := NewMemOutput()
+	errs := GenerateFromConfig(GenConfig{
+		Tag:             "x",
+		Output:          mem,
+		PkgPath:         "foo",
+		NumTestPackages: 1,
+		Seed:            5,
+		MaxFail:         10,
+		RandCtl:         RandCtlChecks | RandCtlPanic,
+		TypeCheck:       true,
+		Specs:           specs,
+	})
+	if errs != 0 {
+		t.Fatalf("%d errors during Generate", errs)
+	}
+	checkSpecDecls(t, mem.Files(), "x", 1, specs)
+
+	if _, _, err := SpecsFromPackage(fixture + "generic"); err == nil {
+		t.Errorf("generic-only package accepted")
+	}
+	if _, _, err := SpecsFromPackage(fixture + "nosuchpkg"); err == nil {
+		t.Errorf("missing package accepted")
+	}
+
+	// Standard library packages are found as well.
+	specs, _, err = SpecsFromPackage("strings")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if want := "// strings.Repeat\nfunc(string, int) string\n"; !strings.Contains(specs.src, want) {
+		t.Errorf("specs missing %q", want)
+	}
+}
+
+func TestArchRegs(t *testing.T) {
+	i64 := &numparm{tag: "int", widthInBits: 64}
+	c128 := &numparm{tag: "complex", widthInBits: 128}
+	for _, tc := range []struct {
+		goarch string
+		p      parm
+		ints   int
+		floats int
+	}{
+		{"amd64", i64, 1, 0},
+		{"386", i64, 2, 0},
+		{"amd64", c128, 0, 2},
+		{"386", &stringparm{}, 2, 0},
+	} {
+		a := archRegs[tc.goarch]
+		if ni, nf, _ := a.regsFor(tc.p); ni != tc.ints || nf != tc.floats {
+			t.Errorf("%s %s: got %d/%d regs, want %d/%d", tc.goarch,
+				tc.p.TypeName(), ni, nf, tc.ints, tc.floats)
+		}
+	}
+	if archRegs["386"].hasRegABI() {
+		t.Errorf("386 should not use the register ABI")
+	}
+	if _, _, spilled := archRegs["386"].assignRegs([]parm{i64}); len(spilled) != 1 {
+		t.Errorf("386 params should all be passed in memory")
+	}
+}
+
 func TestIsBuildable(t *testing.T) {
 
 	//Verbctl = 4
@@ -82,7 +1201,16 @@ func TestIsBuildable(t *testing.T) {
 
 	checkTunables(tunables)
 	pack := filepath.Base(td)
-	errs := Generate("x", td, pack, 10, 10, int64(0), "", nil, nil, false, 10, false, RandCtlChecks|RandCtlPanic, false)
+	errs := GenerateFromConfig(GenConfig{
+		Tag:              "x",
+		OutDir:           td,
+		PkgPath:          pack,
+		NumTestFunctions: 10,
+		NumTestPackages:  10,
+		Seed:             int64(0),
+		MaxFail:          10,
+		RandCtl:          RandCtlChecks | RandCtlPanic,
+	})
 	if errs != 0 {
 		t.Errorf("%d errors during Generate", errs)
 	}
@@ -109,6 +1237,8 @@ func TestExhaustive(t *testing.T) {
 
 	verb(1, "generating into temp dir %s", td)
 
+	testpar := 0
+	repeat := 1
 	scenarios := []struct {
 		name     string
 		adjuster func()
@@ -123,6 +1253,10 @@ func TestExhaustive(t *testing.T) {
 				tunables.methodPerc = 0
 				tunables.doReflectCall = false
 				tunables.doDefer = false
+				tunables.doPanic = false
+				tunables.doMutate = false
+				tunables.sliceAliasFraction = 0
+				tunables.doGo = false
 				tunables.takeAddress = false
 				tunables.doFuncCallValues = false
 				tunables.doSkipCompare = false
@@ -175,6 +1309,70 @@ func TestExhaustive(t *testing.T) {
 				checkTunables(tunables)
 			},
 		},
+		{
+			"addgo",
+			func() {
+				tunables.doGo = true
+				tunables.goFraction = 30
+				checkTunables(tunables)
+			},
+		},
+		{
+			"addpanic",
+			func() {
+				tunables.doPanic = true
+				tunables.panicFraction = 30
+				checkTunables(tunables)
+			},
+		},
+		{
+			"addmutate",
+			func() {
+				tunables.doMutate = true
+				tunables.mutateFraction = 60
+				checkTunables(tunables)
+			},
+		},
+		{
+			"addslicealias",
+			func() {
+				tunables.sliceAliasFraction = 50
+				checkTunables(tunables)
+			},
+		},
+		{
+			"morenilmaps",
+			func() {
+				tunables.nMapEntries = 6
+				tunables.nilFraction = 80
+				tunables.specialKeyFraction = 60
+				checkTunables(tunables)
+			},
+		},
+		{
+			"morestrings",
+			func() {
+				tunables.stringKindFractions = [5]uint8{10, 30, 20, 20, 20}
+				tunables.stringViaBytesFraction = 50
+				checkTunables(tunables)
+			},
+		},
+		{
+			"morereturnstyles",
+			func() {
+				tunables.returnStyleFractions = [3]uint8{20, 40, 40}
+				checkTunables(tunables)
+			},
+		},
+		{
+			"addmethodcalls",
+			func() {
+				tunables.methodPerc = 60
+				tunables.pointerMethodCallPerc = 50
+				tunables.recurPerc = 0
+				checkTunables(tunables)
+			},
+		},
 		{
 			"addfuncval",
 			func() {
@@ -189,6 +1387,34 @@ func TestExhaustive(t *testing.T) {
 				checkTunables(tunables)
 			},
 		},
+		{
+			"addregboundary",
+			func() {
+				tunables.regBoundaryPerc = 50
+				checkTunables(tunables)
+			},
+		},
+		{
+			"addzerosize",
+			func() {
+				tunables.zeroSizePerc = 50
+				checkTunables(tunables)
+			},
+		},
+		{
+			"addtestpar",
+			func() {
+				testpar = 4
+			},
+		},
+		{
+			"addgcinject",
+			func() {
+				tunables.gcInjectFraction = 20
+				checkTunables(tunables)
+				repeat = 3
+			},
+		},
 	}
 
 	// Loop over scenarios and make sure each one works properly.
@@ -197,9 +1423,22 @@ func TestExhaustive(t *testing.T) {
 		s.adjuster()
 		os.RemoveAll(td)
 		pack := filepath.Base(td)
-		errs := Generate("x", td, pack, 10, 10, int64(i+9), "", nil, nil, false, 10, false, RandCtlChecks|RandCtlPanic, false)
+		errs := GenerateFromConfig(GenConfig{
+			Tag:              "x",
+			OutDir:           td,
+			PkgPath:          pack,
+			NumTestFunctions: 10,
+			NumTestPackages:  10,
+			Seed:             int64(i + 9),
+			MaxFail:          10,
+			RandCtl:          RandCtlChecks | RandCtlPanic,
+			TestParallelism:  testpar,
+			Repeat:           repeat,
+			RepeatHook:       "cycle",
+			TypeCheck:        true,
+		})
 		if errs != 0 {
-			t.Errorf("%d errors during scenarios %q Generate", errs, s.name)
+			t.Fatalf("%d errors during scenarios %q Generate", errs, s.name)
 		}
 		cmd := exec.Command("go", "run", ".")
 		cmd.Dir = td
@@ -5,11 +5,15 @@ import (
 	"crypto/sha1"
 	"errors"
 	"fmt"
+	"go/ast"
+	"io"
 	"log"
 	"os"
 	"os/exec"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 type TunableParams struct {
@@ -28,6 +32,18 @@ type TunableParams struct {
 	// fraction of slices vs arrays
 	sliceFraction uint8
 
+	// maps have between 0 and N entries
+	nMapEntries uint8
+
+	// fraction of maps whose keys are of a special type (struct
+	// with float field, array, string, interface) set up so that
+	// some of the keys collide
+	specialKeyFraction uint8
+
+	// fraction of empty slices and maps that are nil as opposed
+	// to non-nil with zero length
+	nilFraction uint8
+
 	// Controls how often ints wind up as 8/16/32/64, should
 	// add up to 100. Ex: 100 0 0 0 means all ints are 8 bit,
 	// 25 25 25 25 means equal likelihood of all types.
@@ -36,6 +52,17 @@ type TunableParams struct {
 	// Similar to the above but for 32/64 float types
 	floatBitRanges [2]uint8
 
+	// Controls the distribution of string values: empty, short
+	// (a few runes), long (multi-KB), raw bytes (NULs, invalid
+	// UTF-8), and substrings of a shared global. Should add up
+	// to 100.
+	stringKindFractions [5]uint8
+
+	// fraction of string params and results that are passed via
+	// a []byte -> string conversion (and of byte slices passed via
+	// a string -> []byte conversion)
+	stringViaBytesFraction uint8
+
 	// Similar to the above but for unsigned, signed ints.
 	unsignedRanges [2]uint8
 
@@ -80,12 +107,47 @@ type TunableParams struct {
 	// a global.
 	addrFractions [4]uint8
 
-	// If true, then perform testing of go/defer statements.
+	// If true, then perform testing of defer statements.
 	doDefer bool
 
 	// fraction of test functions for which we emit a defer
 	deferFraction uint8
 
+	// If true, then perform testing of go statements.
+	doGo bool
+
+	// fraction of test functions for which we check params on a
+	// new goroutine
+	goFraction uint8
+
+	// If true, then emit test functions that panic after checking
+	// their params and then recover in a deferred function, which
+	// writes the named results before the function returns.
+	doPanic bool
+
+	// fraction of (non-recursive) test functions for which we emit
+	// a panic/recover sequence
+	panicFraction uint8
+
+	// Controls how test functions return their results: with an
+	// explicit "return rc0, ..." statement, by assigning to the
+	// named results followed by a bare return, or by assigning only
+	// some of the named results and leaving the rest as zero values.
+	// Should add up to 100.
+	returnStyleFractions [3]uint8
+
+	// If true, then have the checker write new values through some
+	// of the pointer, slice and map params it receives, and have the
+	// caller verify that the writes are visible after the call.
+	doMutate bool
+
+	// fraction of eligible params that are written through
+	mutateFraction uint8
+
+	// fraction of test functions that get a group of slice params
+	// sharing a single backing array (with len < cap)
+	sliceAliasFraction uint8
+
 	// If true, randomly pick between emitting a value by literal
 	// (e.g. "int(1)" vs emitting a call to a function that
 	// will produce the same value (e.g. "myHelperEmitsInt1()").
@@ -103,6 +165,29 @@ type TunableParams struct {
 	// Fraction of the time that we decided to skip sub-components of
 	// composite values
 	skipCompareFraction uint8
+
+	// Percentage of test functions whose params (and sometimes
+	// returns) are constructed to sit right at the boundary of
+	// the argument registers available on the target
+	// architecture, as opposed to being randomly generated.
+	regBoundaryPerc uint8
+
+	// Target architecture (GOARCH value) for ABI-specific
+	// generation, such as the register boundary functions above.
+	goarch string
+
+	// Percentage of the candidate points within a test function
+	// (after taking the address of params/returns, after each
+	// param check, and before returning) at which we inject a
+	// call to runtime.GC(), so as to put pressure on heap-escaped
+	// and stack-allocated values.
+	gcInjectFraction uint8
+
+	// Percentage of test functions generated in zero-size mode, in
+	// which zero-size params, returns and receivers (empty structs,
+	// zero-length arrays and the like) are placed in each of the
+	// positions of interest to the register ABI.
+	zeroSizePerc uint8
 }
 
 var defaultTypeFractions = [9]uint8{
@@ -133,30 +218,44 @@ const (
 )
 
 var tunables = TunableParams{
-	nParmRange:            15,
-	nReturnRange:          7,
-	nStructFields:         7,
-	nArrayElements:        5,
-	sliceFraction:         50,
-	intBitRanges:          [4]uint8{30, 20, 20, 30},
-	floatBitRanges:        [2]uint8{50, 50},
-	unsignedRanges:        [2]uint8{50, 50},
-	blankPerc:             15,
-	structDepth:           3,
-	typeFractions:         defaultTypeFractions,
-	recurPerc:             20,
-	methodPerc:            10,
-	pointerMethodCallPerc: 50,
-	doReflectCall:         true,
-	doDefer:               true,
-	takeAddress:           true,
-	doFuncCallValues:      true,
-	takenFraction:         20,
-	deferFraction:         30,
-	funcCallValFraction:   5,
-	doSkipCompare:         true,
-	skipCompareFraction:   10,
-	addrFractions:         [4]uint8{50, 25, 15, 10},
+	nParmRange:             15,
+	nReturnRange:           7,
+	nStructFields:          7,
+	nArrayElements:         5,
+	nMapEntries:            3,
+	specialKeyFraction:     20,
+	nilFraction:            50,
+	sliceFraction:          50,
+	intBitRanges:           [4]uint8{30, 20, 20, 30},
+	floatBitRanges:         [2]uint8{50, 50},
+	stringKindFractions:    [5]uint8{5, 65, 5, 15, 10},
+	stringViaBytesFraction: 10,
+	unsignedRanges:         [2]uint8{50, 50},
+	blankPerc:              15,
+	structDepth:            3,
+	typeFractions:          defaultTypeFractions,
+	recurPerc:              20,
+	methodPerc:             10,
+	pointerMethodCallPerc:  50,
+	doReflectCall:          true,
+	doDefer:                true,
+	doPanic:                true,
+	doGo:                   true,
+	doMutate:               true,
+	takeAddress:            true,
+	doFuncCallValues:       true,
+	takenFraction:          20,
+	deferFraction:          30,
+	panicFraction:          10,
+	returnStyleFractions:   [3]uint8{60, 25, 15},
+	goFraction:             15,
+	mutateFraction:         20,
+	sliceAliasFraction:     10,
+	funcCallValFraction:    5,
+	doSkipCompare:          true,
+	skipCompareFraction:    10,
+	addrFractions:          [4]uint8{50, 25, 15, 10},
+	goarch:                 runtime.GOARCH,
 }
 
 func DefaultTunables() TunableParams {
@@ -173,6 +272,17 @@ func checkTunables(t TunableParams) {
 		log.Fatal(errors.New("intBitRanges tunable does not sum to 100"))
 	}
 
+	s = 0
+	for _, v := range t.stringKindFractions {
+		s += int(v)
+	}
+	if s != 100 {
+		log.Fatal(errors.New("stringKindFractions tunable does not sum to 100"))
+	}
+	if t.stringViaBytesFraction > 100 {
+		log.Fatal(errors.New("stringViaBytesFraction not between 0 and 100"))
+	}
+
 	s = 0
 	for _, v := range t.unsignedRanges {
 		s += int(v)
@@ -184,6 +294,12 @@ func checkTunables(t TunableParams) {
 	if t.blankPerc > 100 {
 		log.Fatal(errors.New("blankPerc bad value, over 100"))
 	}
+	if t.nArrayElements == 0 {
+		log.Fatal(errors.New("nArrayElements tunable must be at least 1"))
+	}
+	if t.nStructFields < t.structDepth {
+		log.Fatal(errors.New("nStructFields tunable must be at least structDepth"))
+	}
 	if t.recurPerc > 100 {
 		log.Fatal(errors.New("recurPerc bad value, over 100"))
 	}
@@ -223,12 +339,49 @@ func checkTunables(t TunableParams) {
 	if t.deferFraction > 100 {
 		log.Fatal(errors.New("deferFraction not between 0 and 100"))
 	}
+	if t.goFraction > 100 {
+		log.Fatal(errors.New("goFraction not between 0 and 100"))
+	}
+	if t.panicFraction > 100 {
+		log.Fatal(errors.New("panicFraction not between 0 and 100"))
+	}
+	s = 0
+	for _, v := range t.returnStyleFractions {
+		s += int(v)
+	}
+	if s != 100 {
+		log.Fatal(errors.New("returnStyleFractions tunable does not sum to 100"))
+	}
+	if t.specialKeyFraction > 100 {
+		log.Fatal(errors.New("specialKeyFraction not between 0 and 100"))
+	}
+	if t.nilFraction > 100 {
+		log.Fatal(errors.New("nilFraction not between 0 and 100"))
+	}
+	if t.mutateFraction > 100 {
+		log.Fatal(errors.New("mutateFraction not between 0 and 100"))
+	}
+	if t.sliceAliasFraction > 100 {
+		log.Fatal(errors.New("sliceAliasFraction not between 0 and 100"))
+	}
 	if t.sliceFraction > 100 {
 		log.Fatal(errors.New("sliceFraction not between 0 and 100"))
 	}
 	if t.skipCompareFraction > 100 {
 		log.Fatal(errors.New("skipCompareFraction not between 0 and 100"))
 	}
+	if t.regBoundaryPerc > 100 {
+		log.Fatal(errors.New("regBoundaryPerc not between 0 and 100"))
+	}
+	if t.gcInjectFraction > 100 {
+		log.Fatal(errors.New("gcInjectFraction not between 0 and 100"))
+	}
+	if t.zeroSizePerc > 100 {
+		log.Fatal(errors.New("zeroSizePerc not between 0 and 100"))
+	}
+	if _, ok := archRegs[t.goarch]; !ok {
+		log.Fatal(fmt.Errorf("unknown target architecture %q", t.goarch))
+	}
 }
 
 func SetTunables(t TunableParams) {
@@ -256,6 +409,65 @@ func (t *TunableParams) DisableDefer() {
 	t.doDefer = false
 }
 
+func (t *TunableParams) DisableGo() {
+	t.doGo = false
+}
+
+func (t *TunableParams) DisablePanic() {
+	t.doPanic = false
+}
+
+func (t *TunableParams) DisableMutate() {
+	t.doMutate = false
+}
+
+func (t *TunableParams) DisableSliceAlias() {
+	t.sliceAliasFraction = 0
+}
+
+func (t *TunableParams) SetGoarch(goarch string) error {
+	if _, ok := archRegs[goarch]; !ok {
+		return fmt.Errorf("unknown target architecture %q", goarch)
+	}
+	t.goarch = goarch
+	return nil
+}
+
+// ErrNoRegABI is returned by EnableRegBoundary when the target
+// architecture has no register-based ABI, and so no register boundary
+// to test.
+var ErrNoRegABI = errors.New("target architecture has no register ABI")
+
+// EnableRegBoundary arranges for 'perc' percent of the test functions
+// to have register boundary signatures. Call SetGoarch first, since
+// whether this is possible depends on the target.
+func (t *TunableParams) EnableRegBoundary(perc int) error {
+	if perc < 0 || perc > 100 {
+		return fmt.Errorf("value %d passed to EnableRegBoundary is not between 0 and 100", perc)
+	}
+	if perc != 0 && !archRegs[t.goarch].hasRegABI() {
+		return fmt.Errorf("register boundary functions for GOARCH=%s: %w", t.goarch, ErrNoRegABI)
+	}
+	t.regBoundaryPerc = uint8(perc)
+	return nil
+}
+
+func (t *TunableParams) InjectGC(perc int) error {
+	if perc < 0 || perc > 100 {
+		return fmt.Errorf("value %d passed to InjectGC is not between 0 and 100", perc)
+	}
+	t.gcInjectFraction = uint8(perc)
+	return nil
+}
+
+func (t *TunableParams) EnableZeroSize(perc int) error {
+	if perc < 0 || perc > 100 {
+		return fmt.Errorf("value %d passed to EnableZeroSize is not between 0 and 100", perc)
+	}
+	t.zeroSizePerc = uint8(perc)
+	return nil
+}
+
 func (t *TunableParams) LimitInputs(n int) error {
 	if n > 100 {
 		return fmt.Errorf("value %d passed to LimitInputs is too large *(max 100)", n)
@@ -301,6 +513,7 @@ type funcdef struct {
 	mapdefs     []mapparm
 	mapkeytypes []parm
 	mapkeytmps  []string
+	mapkeyfix   [][]string
 	mapkeyts    string
 	receiver    parm
 	params      []parm
@@ -308,13 +521,28 @@ type funcdef struct {
 	values      []int
 	dodefc      uint8
 	dodefp      []uint8
+	dogoc       uint8
+	dogop       []uint8
+	mutp        []bool
+	alias       *sliceAlias
 	rstack      int
 	recur       bool
 	method      bool
+	ptrrcvr     bool
+	mcall       int
+	regboundary bool
+	rbreturns   bool
+	zerosize    bool
+	retstyle    int
+	retzero     []bool
+	gcpoints    []bool
+	dopanic     bool
+	panicval    string
+	panicparm   parm
 }
 
 type genstate struct {
-	outdir         string
+	out            Output
 	ipref          string
 	tag            string
 	numtpk         int
@@ -323,8 +551,12 @@ type genstate struct {
 	pragma         string
 	sforce         bool
 	randctl        int
+	testpar        int
+	repeat         int
+	rhook          string
 	tunables       TunableParams
 	tstack         []TunableParams
+	funcs          []ManifestFunc
 	derefFuncs     map[string]string
 	newDerefFuncs  []funcdesc
 	assignFuncs    map[string]string
@@ -336,6 +568,11 @@ type genstate struct {
 	globVars       map[string]string
 	newGlobVars    []funcdesc
 	wr             *wraprand
+	stats          GenStats
+	typecheck      bool
+	files          []*genFile
+	specs          *Specs
+	spec           *ast.FuncType // signature of the current function, if any
 }
 
 func (s *genstate) intFlavor() string {
@@ -360,6 +597,74 @@ func (s *genstate) intBits() uint32 {
 	return uint32(s.tunables.intBitRanges[3])
 }
 
+// Return styles for test functions.
+const (
+	// return rc0, rc1, ...
+	retExplicit = iota
+	// r0 = rc0; r1 = rc1; ...; return
+	retNamedBare
+	// as above, but some of the results are left unassigned
+	retPartial
+)
+
+// returnStyle selects a return style (retExplicit, etc) according
+// to the returnStyleFractions tunable.
+func (s *genstate) returnStyle() int {
+	which := uint8(s.wr.Intn(100))
+	var t uint8 = 0
+	for k, v := range s.tunables.returnStyleFractions {
+		t += v
+		if which < t {
+			return k
+		}
+	}
+	return retExplicit
+}
+
+// retZero returns true if the checker for 'f' leaves result 'ri'
+// unassigned (meaning that the caller sees the zero value).
+func (f *funcdef) retZero(ri int) bool {
+	return f.retzero != nil && f.retzero[ri]
+}
+
+// zeroable returns true if the caller can compare a zero value of
+// type 'p' against what it gets back without dereferencing (or
+// indexing) anything along the way.
+func zeroable(p parm) bool {
+	switch x := p.(type) {
+	case *numparm, *stringparm:
+		return true
+	case *structparm:
+		for _, fld := range x.fields {
+			if !zeroable(fld) {
+				return false
+			}
+		}
+		return true
+	case *arrayparm:
+		return !x.slice && zeroable(x.eltype)
+	case *mapparm:
+		return zeroable(x.valtype)
+	case *typedefparm:
+		return zeroable(x.target)
+	}
+	return false
+}
+
+// stringKind selects a flavor of string value (strEmpty, strShort,
+// etc) according to the stringKindFractions tunable.
+func (s *genstate) stringKind() int {
+	which := uint8(s.wr.Intn(100))
+	var t uint8 = 0
+	for k, v := range s.tunables.stringKindFractions {
+		t += v
+		if which < t {
+			return k
+		}
+	}
+	return strShort
+}
+
 func (s *genstate) floatBits() uint32 {
 	which := uint8(s.wr.Intn(100))
 	if which < s.tunables.floatBitRanges[0] {
@@ -390,8 +695,9 @@ func (s *genstate) popTunables() {
 	if len(s.tstack) == 0 {
 		panic("untables stack underflow")
 	}
-	s.tunables = s.tstack[0]
-	s.tstack = s.tstack[1:]
+	n := len(s.tstack) - 1
+	s.tunables = s.tstack[n]
+	s.tstack = s.tstack[:n]
 }
 
 func (s *genstate) dumpTypeFraction(tag string) {
@@ -415,6 +721,10 @@ func (s *genstate) dumpTypeFraction(tag string) {
 }
 
 func (s *genstate) redistributeFraction(f uint8, avoid []int) {
+	if f == 0 {
+		// nothing to hand out (and doredis would wrap around)
+		return
+	}
 	inavoid := func(j int) bool {
 		for _, k := range avoid {
 			if j == k {
@@ -463,6 +773,90 @@ func (s *genstate) GenMapKeyType(f *funcdef, depth int, pidx int) parm {
 	return s.GenParm(f, depth+1, false, pidx)
 }
 
+// genMapParm creates a new map type for 'f'. If 'keytype' or
+// 'valtype' is nil, the map key or value type is randomly generated
+// as well.
+func (s *genstate) genMapParm(f *funcdef, depth int, pidx int, keytype, valtype parm) *mapparm {
+	var mp mapparm
+	ns := len(f.mapdefs)
+
+	// append early, since calls below might also append
+	f.mapdefs = append(f.mapdefs, mp)
+	kidx := len(f.mapkeytmps)
+	f.mapkeytmps = append(f.mapkeytmps, "")
+	f.mapkeytypes = append(f.mapkeytypes, mp.keytype)
+	f.mapkeyfix = append(f.mapkeyfix, nil)
+	mp.aname = fmt.Sprintf("MapF%dM%d", f.idx, ns)
+	if f.mapkeyts == "" {
+		f.mapkeyts = fmt.Sprintf("MapKeysF%d", f.idx)
+	}
+	mp.qname = fmt.Sprintf("%s.MapF%dM%d", s.checkerPkg(pidx),
+		f.idx, ns)
+	mkt := fmt.Sprintf("Mk%dt%d", f.idx, ns)
+	special := -1
+	if keytype == nil && s.tunables.specialKeyFraction != 0 &&
+		uint8(s.wr.Intn(100)) < s.tunables.specialKeyFraction {
+		special = s.wr.Intn(numSpecialKeys)
+	}
+	mk := keytype
+	if special >= 0 {
+		mk = s.genSpecialKeyType(f, special, pidx)
+	} else if mk == nil {
+		mk = s.GenMapKeyType(f, depth+1, pidx)
+	}
+	mp.keytype = mk
+	if valtype == nil {
+		valtype = s.GenParm(f, depth+1, false, pidx)
+	}
+	mp.valtype = valtype
+	mp.valtype.SetBlank(false)
+	mp.keytype.SetBlank(false)
+	var nent int
+	if special >= 0 {
+		nent = 2 + s.wr.Intn(3)
+	} else {
+		nent = s.wr.Intn(int(s.tunables.nMapEntries) + 1)
+		if nent == 0 {
+			mp.isnil = uint8(s.wr.Intn(100)) < s.tunables.nilFraction
+		} else if keyLeaf(mk) < 0 {
+			// no way to make keys distinct
+			nent = 1
+		}
+	}
+	for e := 0; e < nent; e++ {
+		mp.keytmps = append(mp.keytmps, fmt.Sprintf("%sk%d", mkt, e))
+	}
+	// Work out the fixups for each key. For special keys,
+	// the first key is shadowed by the second. Otherwise if
+	// there is more than one entry, each key gets a distinct
+	// value in its discriminator leaf.
+	fixes := make([][]string, nent)
+	if special >= 0 {
+		fixes = s.specialKeyFixups(special, mp.keytmps)
+		mp.shadowed = make([]bool, nent)
+		mp.shadowed[0] = true
+	} else if nent > 1 {
+		for e, kt := range mp.keytmps {
+			fixes[e] = []string{discFixup(mk, kt, e)}
+		}
+	}
+	// now update the previously appended placeholders, then
+	// add temps for any additional keys.
+	f.mapdefs[ns] = mp
+	f.mapkeytypes[kidx] = mk
+	f.mapkeytmps[kidx] = mkt
+	if nent != 0 {
+		f.mapkeytmps[kidx] = mp.keytmps[0]
+		f.mapkeyfix[kidx] = fixes[0]
+		for e := 1; e < nent; e++ {
+			f.mapkeytypes = append(f.mapkeytypes, mk)
+			f.mapkeytmps = append(f.mapkeytmps, mp.keytmps[e])
+			f.mapkeyfix = append(f.mapkeyfix, fixes[e])
+		}
+	}
+	return &mp
+}
+
 func (s *genstate) GenParm(f *funcdef, depth int, mkctl bool, pidx int) parm {
 
 	// Enforcement for struct/array/map/pointer array nesting depth.
@@ -536,6 +930,9 @@ func (s *genstate) GenParm(f *funcdef, depth int, mkctl bool, pidx int) parm {
 			ap.slice = issl
 			ap.eltype = s.GenParm(f, depth+1, false, pidx)
 			ap.eltype.SetBlank(false)
+			if issl && nel == 0 {
+				ap.isnil = uint8(s.wr.Intn(100)) < s.tunables.nilFraction
+			}
 			skComp := tunables.doSkipCompare &&
 				uint8(s.wr.Intn(100)) < s.tunables.skipCompareFraction
 			if skComp && checkableElements(ap.eltype) != 0 {
@@ -551,31 +948,7 @@ func (s *genstate) GenParm(f *funcdef, depth int, mkctl bool, pidx int) parm {
 			if toodeep {
 				panic("should not be here")
 			}
-			var mp mapparm
-			ns := len(f.mapdefs)
-
-			// append early, since calls below might also append
-			f.mapdefs = append(f.mapdefs, mp)
-			f.mapkeytmps = append(f.mapkeytmps, "")
-			f.mapkeytypes = append(f.mapkeytypes, mp.keytype)
-			mp.aname = fmt.Sprintf("MapF%dM%d", f.idx, ns)
-			if f.mapkeyts == "" {
-				f.mapkeyts = fmt.Sprintf("MapKeysF%d", f.idx)
-			}
-			mp.qname = fmt.Sprintf("%s.MapF%dM%d", s.checkerPkg(pidx),
-				f.idx, ns)
-			mkt := fmt.Sprintf("Mk%dt%d", f.idx, ns)
-			mp.keytmp = mkt
-			mk := s.GenMapKeyType(f, depth+1, pidx)
-			mp.keytype = mk
-			mp.valtype = s.GenParm(f, depth+1, false, pidx)
-			mp.valtype.SetBlank(false)
-			mp.keytype.SetBlank(false)
-			// now update the previously appended placeholders
-			f.mapdefs[ns] = mp
-			f.mapkeytypes[ns] = mk
-			f.mapkeytmps[ns] = mkt
-			retval = &mp
+			retval = s.genMapParm(f, depth, pidx, nil, nil)
 		}
 	case which < tf[PointerTfIdx]:
 		{
@@ -648,6 +1021,21 @@ func (s *genstate) GenReturn(f *funcdef, depth int, pidx int) parm {
 	return s.GenParm(f, depth, false, pidx)
 }
 
+// insertParm inserts 'p' into the param list of 'f' at position
+// 'pos', keeping the defer choices and any alias group in sync.
+func (s *genstate) insertParm(f *funcdef, pos int, p parm) {
+	if f.alias != nil {
+		for j := range f.alias.members {
+			if f.alias.members[j].pidx >= pos {
+				f.alias.members[j].pidx++
+			}
+		}
+	}
+	f.params = append(f.params[:pos], append([]parm{p}, f.params[pos:]...)...)
+	dd := uint8(s.wr.Intn(100))
+	f.dodefp = append(f.dodefp[:pos], append([]uint8{dd}, f.dodefp[pos:]...)...)
+}
+
 func (s *genstate) GenFunc(fidx int, pidx int) *funcdef {
 	f := new(funcdef)
 	f.idx = fidx
@@ -655,22 +1043,76 @@ func (s *genstate) GenFunc(fidx int, pidx int) *funcdef {
 	numReturns := s.wr.Intn(1 + int(s.tunables.nReturnRange))
 	f.recur = uint8(s.wr.Intn(100)) < s.tunables.recurPerc
 	f.method = uint8(s.wr.Intn(100)) < s.tunables.methodPerc
+	if s.spec != nil {
+		// User-supplied signatures are used as is, so there's no
+		// room for a receiver or control param (and the context
+		// isn't passed as a param either; see ctxInParams).
+		f.recur = false
+		f.method = false
+	} else if s.tunables.regBoundaryPerc != 0 && archRegs[s.tunables.goarch].hasRegABI() {
+		// Register boundary functions don't have receivers or
+		// control params, since these would throw off the
+		// register accounting.
+		f.regboundary = uint8(s.wr.Intn(100)) < s.tunables.regBoundaryPerc
+		if f.regboundary {
+			f.recur = false
+			f.method = false
+		}
+	}
+	if s.spec == nil && s.tunables.zeroSizePerc != 0 {
+		f.zerosize = uint8(s.wr.Intn(100)) < s.tunables.zeroSizePerc
+	}
 	if f.method {
 		// Receiver type can't be pointer type. Temporarily update
 		// tunables to eliminate that possibility.
-		s.pushTunables()
-		s.precludeSelectedTypes(PointerTfIdx)
-		target := s.GenParm(f, 0, false, pidx)
-		target.SetBlank(false)
-		s.popTunables()
+		var target parm
+		if f.zerosize {
+			target = s.genZeroSizeParm(f, 0, pidx)
+		} else {
+			s.pushTunables()
+			s.precludeSelectedTypes(PointerTfIdx)
+			target = s.GenParm(f, 0, false, pidx)
+			target.SetBlank(false)
+			s.popTunables()
+		}
 		f.receiver = s.makeTypedefParm(f, target, pidx)
-		if f.receiver.IsBlank() {
+		// Pointer methods write new values to the receiver before
+		// returning, which would throw off recursive calls.
+		f.ptrrcvr = uint8(s.wr.Intn(100)) < s.tunables.pointerMethodCallPerc
+		if f.receiver.IsBlank() || f.ptrrcvr {
 			f.recur = false
 		}
+		f.mcall = s.wr.Intn(numMethodCallShapes)
+		if f.ptrrcvr {
+			// The method set of T doesn't include pointer methods.
+			switch f.mcall {
+			case mcallMethodExpr:
+				f.mcall = mcallPtrMethodExpr
+			case mcallInterface:
+				f.mcall = mcallPtrInterface
+			}
+		}
 	}
 	needControl := f.recur
 	f.dodefc = uint8(s.wr.Intn(100))
 	pTaken := uint8(s.wr.Intn(100)) < s.tunables.takenFraction
+	if f.regboundary {
+		f.params = s.genRegBoundaryParms(f, s.paramRegs(), pidx)
+		numParams = 0
+		for range f.params {
+			f.dodefp = append(f.dodefp, uint8(s.wr.Intn(100)))
+		}
+	}
+	if s.spec != nil {
+		f.params = s.genSpecParms(f, s.spec.Params, pidx)
+		numParams = 0
+		for _, p := range f.params {
+			if !pTaken {
+				p.SetAddrTaken(notAddrTaken)
+			}
+			f.dodefp = append(f.dodefp, uint8(s.wr.Intn(100)))
+		}
+	}
 	for pi := 0; pi < numParams; pi++ {
 		newparm := s.GenParm(f, 0, needControl, pidx)
 		if !pTaken {
@@ -685,8 +1127,29 @@ func (s *genstate) GenFunc(fidx int, pidx int) *funcdef {
 	if f.recur && needControl {
 		f.recur = false
 	}
+	if s.tunables.sliceAliasFraction != 0 && !f.regboundary && s.spec == nil &&
+		uint8(s.wr.Intn(100)) < s.tunables.sliceAliasFraction {
+		s.genSliceAlias(f, pidx)
+	}
+	if f.zerosize {
+		s.genZeroSizeParms(f, pidx)
+	}
 
 	rTaken := uint8(s.wr.Intn(100)) < s.tunables.takenFraction
+	if f.regboundary && s.wr.Intn(100) < 50 {
+		f.returns = s.genRegBoundaryParms(f, archRegs[s.tunables.goarch], pidx)
+		f.rbreturns = true
+		numReturns = 0
+	}
+	if s.spec != nil {
+		f.returns = s.genSpecParms(f, s.spec.Results, pidx)
+		numReturns = 0
+		if !rTaken {
+			for _, r := range f.returns {
+				r.SetAddrTaken(notAddrTaken)
+			}
+		}
+	}
 	for ri := 0; ri < numReturns; ri++ {
 		r := s.GenReturn(f, 0, pidx)
 		if !rTaken {
@@ -694,6 +1157,60 @@ func (s *genstate) GenFunc(fidx int, pidx int) *funcdef {
 		}
 		f.returns = append(f.returns, r)
 	}
+	if f.zerosize {
+		s.genZeroSizeReturns(f, pidx)
+	}
+	if s.tunables.returnStyleFractions[retExplicit] != 100 {
+		f.retstyle = s.returnStyle()
+		if f.retstyle == retPartial {
+			for _, r := range f.returns {
+				z := s.wr.Intn(100) < 50
+				f.retzero = append(f.retzero, z && zeroable(r))
+			}
+		}
+	}
+	if s.tunables.doGo {
+		f.dogoc = uint8(s.wr.Intn(100))
+		for range f.params {
+			f.dogop = append(f.dogop, uint8(s.wr.Intn(100)))
+		}
+	}
+	if s.tunables.doMutate {
+		// Recursive calls would see the new values written by
+		// the checker, so don't mutate params in that case.
+		for _, p := range f.params {
+			mut := uint8(s.wr.Intn(100)) < s.tunables.mutateFraction
+			f.mutp = append(f.mutp, mut && !f.recur && mutable(p) &&
+				f.alias.member(len(f.mutp)) == nil)
+		}
+	}
+	if s.tunables.stringViaBytesFraction != 0 {
+		for pi, p := range f.params {
+			keep := f.alias.member(pi) != nil || (f.mutp != nil && f.mutp[pi])
+			s.pickConversion(p, keep)
+		}
+		for _, r := range f.returns {
+			s.pickConversion(r, false)
+		}
+	}
+	if s.tunables.doPanic && !f.recur &&
+		uint8(s.wr.Intn(100)) < s.tunables.panicFraction {
+		// Pick a scalar type for the panic value; since the value
+		// is only used within the checker, we generate it here
+		// as opposed to in emitChecker.
+		f.dopanic = true
+		f.panicparm = s.GenParm(f, int(s.tunables.structDepth), false, pidx)
+		f.panicparm.SetBlank(false)
+		f.panicparm.SetIsGenVal(false)
+		f.panicparm.SetSkipCompare(SkipNone)
+		f.panicval, _ = f.panicparm.GenValue(s, f, 0, false)
+	}
+	if s.tunables.gcInjectFraction != 0 {
+		for i := 0; i < len(f.params)+2; i++ {
+			inj := uint8(s.wr.Intn(100)) < s.tunables.gcInjectFraction
+			f.gcpoints = append(f.gcpoints, inj)
+		}
+	}
 	spw := uint(s.wr.Intn(11))
 	rstack := 1 << spw
 	if rstack < 4 {
@@ -716,6 +1233,101 @@ func genDeref(p parm) (parm, string) {
 	}
 }
 
+// isZeroSize returns true if values of type 'p' occupy no memory
+// (and as a result there is nothing to compare).
+func isZeroSize(p parm) bool {
+	switch x := p.(type) {
+	case *structparm:
+		for _, fld := range x.fields {
+			if !isZeroSize(fld) {
+				return false
+			}
+		}
+		return true
+	case *arrayparm:
+		return !x.slice && (x.nelements == 0 || isZeroSize(x.eltype))
+	case *typedefparm:
+		return isZeroSize(x.target)
+	}
+	return false
+}
+
+// isStringParm returns true if 'p' is a string or a typedef of one.
+func isStringParm(p parm) bool {
+	switch x := p.(type) {
+	case *stringparm:
+		return true
+	case *typedefparm:
+		return isStringParm(x.target)
+	}
+	return false
+}
+
+// keyLeaf returns the index of an element within map key type 'p'
+// that can serve as a discriminator (a number or string that takes
+// part in key comparisons), or -1 if there is no such element.
+func keyLeaf(p parm) int {
+	for i := 0; i < p.NumElements(); i++ {
+		elref, elparm := p.GenElemRef(i, "k")
+		if elref == "" || strings.HasPrefix(elref, "_") {
+			continue
+		}
+		if isZeroSize(elparm) {
+			continue
+		}
+		return i
+	}
+	return -1
+}
+
+// visitRefNodes walks the type 'p' rooted at expression 'path',
+// invoking 'visit' for each slice or map reachable from it, parents
+// before children.
+func visitRefNodes(p parm, path string, visit func(path string, isnil bool, n int)) {
+	switch x := p.(type) {
+	case *arrayparm:
+		if x.slice {
+			visit(path, x.isnil, int(x.nelements))
+		}
+		for i := 0; i < int(x.nelements); i++ {
+			visitRefNodes(x.eltype, fmt.Sprintf("%s[%d]", path, i), visit)
+		}
+	case *mapparm:
+		visit(path, x.isnil, x.numDistinct())
+		for ki, kt := range x.keytmps {
+			if x.shadowed != nil && x.shadowed[ki] {
+				continue
+			}
+			visitRefNodes(x.valtype, fmt.Sprintf("%s[mkt.%s]", path, kt), visit)
+		}
+	case *structparm:
+		for fi, fld := range x.fields {
+			if fn := x.FieldName(fi); fn != "_" {
+				visitRefNodes(fld, path+"."+fn, visit)
+			}
+		}
+	case *typedefparm:
+		visitRefNodes(x.target, path, visit)
+	case *pointerparm:
+		visitRefNodes(x.totype, "(*"+path+")", visit)
+	}
+}
+
+// nilChecks returns a list of conditions that hold if the slices and
+// maps reachable from 'path' (of type 'p') have the expected nil-ness
+// and length.
+func nilChecks(p parm, path string) []string {
+	conds := []string{}
+	visitRefNodes(p, path, func(path string, isnil bool, n int) {
+		if isnil {
+			conds = append(conds, path+" == nil")
+		} else {
+			conds = append(conds, fmt.Sprintf("%s != nil && len(%s) == %d", path, path, n))
+		}
+	})
+	return conds
+}
+
 func (s *genstate) eqFuncRef(f *funcdef, t parm, caller bool) string {
 	cp := ""
 	if f.mapkeyts != "" {
@@ -740,8 +1352,27 @@ func (s *genstate) emitCompareFunc(f *funcdef, b *bytes.Buffer, p parm) {
 	}
 	b.WriteString(fmt.Sprintf("func %sEqual%s(left %s, right %s) bool {\n", rcvr, tn, tn, tn))
 	b.WriteString("  return ")
-	numel := p.NumElements()
 	ncmp := 0
+
+	// check nil-ness and lengths first, so as to avoid indexing
+	// past the end of a slice below
+	lpaths, rpaths := []string{}, []string{}
+	visitRefNodes(p, "left", func(path string, isnil bool, n int) {
+		lpaths = append(lpaths, path)
+	})
+	visitRefNodes(p, "right", func(path string, isnil bool, n int) {
+		rpaths = append(rpaths, path)
+	})
+	for k := range lpaths {
+		if ncmp != 0 {
+			b.WriteString("  && ")
+		}
+		ncmp++
+		b.WriteString(fmt.Sprintf("(%s == nil) == (%s == nil) && len(%s) == len(%s)",
+			lpaths[k], rpaths[k], lpaths[k], rpaths[k]))
+	}
+
+	numel := p.NumElements()
 	for i := 0; i < numel; i++ {
 		lelref, lelparm := p.GenElemRef(i, "left")
 		relref, _ := p.GenElemRef(i, "right")
@@ -864,6 +1495,9 @@ func (s *genstate) emitMapKeyTmps(f *funcdef, b *bytes.Buffer, pidx int, value i
 		keystr, value = s.GenValue(f, t, value, caller)
 		tname := f.mapkeytmps[i]
 		b.WriteString(fmt.Sprintf("  %s := %s\n", tname, keystr))
+		for _, fix := range f.mapkeyfix[i] {
+			b.WriteString("  " + fix + "\n")
+		}
 		b.WriteString(fmt.Sprintf("  mkt.%s = %s\n", tname, tname))
 	}
 	return value
@@ -871,9 +1505,12 @@ func (s *genstate) emitMapKeyTmps(f *funcdef, b *bytes.Buffer, pidx int, value i
 
 func (s *genstate) emitCaller(f *funcdef, b *bytes.Buffer, pidx int) {
 
-	b.WriteString(fmt.Sprintf("func Caller%d(mode string) {\n", f.idx))
+	b.WriteString(fmt.Sprintf("func Caller%d(ctx *%s.TestCtx, mode string) {\n", f.idx, s.utilsPkg()))
 
-	b.WriteString(fmt.Sprintf("  %s.BeginFcn(%d)\n", s.utilsPkg(), pidx))
+	b.WriteString("  ctx.BeginFcn()\n")
+	if !s.ctxInParams() {
+		b.WriteString(fmt.Sprintf("  %s.%s = ctx\n", s.checkerPkg(pidx), s.ctxVar(f)))
+	}
 
 	var value int = 1
 
@@ -885,18 +1522,31 @@ func (s *genstate) emitCaller(f *funcdef, b *bytes.Buffer, pidx int) {
 	for ri, r := range f.returns {
 		rc := fmt.Sprintf("c%d", ri)
 		value = s.emitVarAssign(f, b, r, rc, value, true)
+		if f.retZero(ri) {
+			b.WriteString(fmt.Sprintf("  %s = *new(%s) // left unassigned by callee\n", rc, r.QualName()))
+		}
 	}
 
+	// generate backing array for aliased slice params
+	value = s.emitAliasBacking(f, b, value, true)
+
 	// generate param constants
 	s.wr.Checkpoint("before param constants")
 	for pi, p := range f.params {
 		verb(4, "emitCaller gen p%d value=%d", pi, value)
-		if p.IsControl() {
+		if m := f.alias.member(pi); m != nil {
+			s.emitAliasMemberAssign(f, b, m)
+		} else if p.IsControl() {
 			_ = uint8(s.wr.Intn(100)) < 50
 			p.Declare(b, fmt.Sprintf("  var p%d ", pi), " = 10\n", true)
 		} else {
 			pc := fmt.Sprintf("p%d", pi)
 			value = s.emitVarAssign(f, b, p, pc, value, true)
+			if isViaBytes(p) {
+				b.WriteString(fmt.Sprintf("  %sb := []byte(%s)\n", pc, pc))
+			} else if isViaString(p) {
+				b.WriteString(fmt.Sprintf("  %ss := string(%s)\n", pc, pc))
+			}
 		}
 		f.values = append(f.values, value)
 	}
@@ -905,12 +1555,32 @@ func (s *genstate) emitCaller(f *funcdef, b *bytes.Buffer, pidx int) {
 	if f.method {
 		s.wr.Checkpoint("before receiver constant")
 		f.receiver.Declare(b, "  var rcvr", "\n", true)
-		valstr, value := s.GenValue(f, f.receiver, value, true)
+		var valstr string
+		valstr, value = s.GenValue(f, f.receiver, value, true)
 		b.WriteString(fmt.Sprintf("  rcvr = %s\n", valstr))
 		f.values = append(f.values, value)
+		if f.ptrrcvr {
+			// expected receiver value after the call
+			f.receiver.Declare(b, "  var rcvrpost", "\n", true)
+			valstr, value = s.GenValue(f, f.receiver, value, true)
+			b.WriteString(fmt.Sprintf("  rcvrpost = %s\n", valstr))
+		}
+	}
+
+	// generate expected values for params written by the checker
+	if f.mutates() {
+		s.wr.Checkpoint("before mutate values")
+		for pi, p := range f.params {
+			if !f.mutp[pi] {
+				continue
+			}
+			var valstr string
+			valstr, value = s.genMutateValue(f, p, value, true)
+			b.WriteString(fmt.Sprintf("  p%dpost := %s\n", pi, valstr))
+		}
 	}
 
-	b.WriteString(fmt.Sprintf("  %s.Mode[%d] = \"\"\n", s.utilsPkg(), pidx))
+	b.WriteString("  ctx.Mode = \"\"\n")
 
 	// calling code
 	b.WriteString(fmt.Sprintf("  // %d returns %d params\n",
@@ -919,6 +1589,21 @@ func (s *genstate) emitCaller(f *funcdef, b *bytes.Buffer, pidx int) {
 		b.WriteString("  hackStack() // force stack growth on next call\n")
 	}
 	b.WriteString("  if mode == \"normal\" {\n")
+	callee := fmt.Sprintf("%s.Test%d", s.checkerPkg(pidx), f.idx)
+	args := []string{}
+	if f.method {
+		var rarg string
+		callee, rarg = s.emitMethodCallee(f, b, pidx)
+		if rarg != "" {
+			args = append(args, rarg)
+		}
+	}
+	if s.ctxInParams() {
+		args = append(args, "ctx")
+	}
+	for pi, p := range f.params {
+		args = append(args, s.genCallArg(p, pi))
+	}
 	b.WriteString("  ")
 	for ri := range f.returns {
 		writeCom(b, ri)
@@ -927,16 +1612,7 @@ func (s *genstate) emitCaller(f *funcdef, b *bytes.Buffer, pidx int) {
 	if len(f.returns) > 0 {
 		b.WriteString(" := ")
 	}
-	pref := s.checkerPkg(pidx)
-	if f.method {
-		pref = "rcvr"
-	}
-	b.WriteString(fmt.Sprintf("%s.Test%d(", pref, f.idx))
-	for pi := range f.params {
-		writeCom(b, pi)
-		b.WriteString(fmt.Sprintf("p%d", pi))
-	}
-	b.WriteString(")\n")
+	b.WriteString(fmt.Sprintf("%s(%s)\n", callee, strings.Join(args, ", ")))
 
 	// checking values returned
 	cm := f.complexityMeasure()
@@ -945,12 +1621,12 @@ func (s *genstate) emitCaller(f *funcdef, b *bytes.Buffer, pidx int) {
 		pfc := ""
 		curp, star := genDeref(rp)
 		// Handle *p where p is an empty struct.
-		if curp.NumElements() == 0 {
+		if isZeroSize(curp) {
 			b.WriteString(fmt.Sprintf("  _, _ = r%d, c%d // zero size\n", ri, ri))
 			continue
 		}
 		if star != "" {
-			pfc = fmt.Sprintf("%s.ParamFailCount[%d] == 0 && ", s.utilsPkg(), pidx)
+			pfc = "ctx.ParamFailCount == 0 && "
 		}
 		if curp.HasPointer() {
 			efn := "!" + s.eqFuncRef(f, curp, true)
@@ -958,7 +1634,7 @@ func (s *genstate) emitCaller(f *funcdef, b *bytes.Buffer, pidx int) {
 		} else {
 			b.WriteString(fmt.Sprintf("  if %s%sr%d != %sc%d {\n", pfc, star, ri, star, ri))
 		}
-		b.WriteString(fmt.Sprintf("    %s.NoteFailure(%d, %d, %d, \"%s\", \"return\", %d, true, uint64(0))\n", s.utilsPkg(), cm, pidx, f.idx, s.checkerPkg(pidx), ri))
+		b.WriteString(fmt.Sprintf("    ctx.NoteFailure(%d, %d, \"%s\", \"return\", %d, true, uint64(0))\n", cm, f.idx, s.checkerPkg(pidx), ri))
 		b.WriteString("  }\n")
 	}
 	b.WriteString("  }")
@@ -966,9 +1642,13 @@ func (s *genstate) emitCaller(f *funcdef, b *bytes.Buffer, pidx int) {
 		b.WriteString("else {\n")
 		// now make the same call via reflection
 		b.WriteString("  // same call via reflection\n")
-		b.WriteString(fmt.Sprintf("  %s.Mode[%d] = \"reflect\"\n", s.utilsPkg(), pidx))
+		b.WriteString("  ctx.Mode = \"reflect\"\n")
 		if f.method {
-			b.WriteString("  rcv := reflect.ValueOf(rcvr)\n")
+			amp := ""
+			if f.ptrrcvr {
+				amp = "&"
+			}
+			b.WriteString(fmt.Sprintf("  rcv := reflect.ValueOf(%srcvr)\n", amp))
 			b.WriteString(fmt.Sprintf("  rc := rcv.MethodByName(\"Test%d\")\n", f.idx))
 		} else {
 			b.WriteString(fmt.Sprintf("  rc := reflect.ValueOf(%s.Test%d)\n",
@@ -979,9 +1659,12 @@ func (s *genstate) emitCaller(f *funcdef, b *bytes.Buffer, pidx int) {
 			b.WriteString("rvslice := ")
 		}
 		b.WriteString("  rc.Call([]reflect.Value{")
-		for pi := range f.params {
-			writeCom(b, pi)
-			b.WriteString(fmt.Sprintf("reflect.ValueOf(p%d)", pi))
+		if s.ctxInParams() {
+			b.WriteString("reflect.ValueOf(ctx)")
+		}
+		for pi, p := range f.params {
+			s.writeParamCom(b, pi)
+			b.WriteString(fmt.Sprintf("reflect.ValueOf(%s)", s.genCallArg(p, pi)))
 		}
 		b.WriteString("})\n")
 
@@ -996,12 +1679,12 @@ func (s *genstate) emitCaller(f *funcdef, b *bytes.Buffer, pidx int) {
 			pfc := ""
 			curp, star := genDeref(r)
 			// Handle *p where p is an empty struct.
-			if curp.NumElements() == 0 {
+			if isZeroSize(curp) {
 				b.WriteString(fmt.Sprintf("  _, _ = rr%dv, c%d // zero size\n", ri, ri))
 				continue
 			}
 			if star != "" {
-				pfc = fmt.Sprintf("%s.ParamFailCount[%d] == 0 && ", s.utilsPkg(), pidx)
+				pfc = "ctx.ParamFailCount == 0 && "
 			}
 			if curp.HasPointer() {
 				efn := "!" + s.eqFuncRef(f, curp, true)
@@ -1009,17 +1692,282 @@ func (s *genstate) emitCaller(f *funcdef, b *bytes.Buffer, pidx int) {
 			} else {
 				b.WriteString(fmt.Sprintf("  if %s%srr%dv != %sc%d {\n", pfc, star, ri, star, ri))
 			}
-			b.WriteString(fmt.Sprintf("    %s.NoteFailure(%d, %d, %d, \"%s\", \"reflect return\", %d, true, uint64(0))\n", s.utilsPkg(), cm, pidx, f.idx, s.checkerPkg(pidx), ri))
+			b.WriteString(fmt.Sprintf("    ctx.NoteFailure(%d, %d, \"%s\", \"reflect return\", %d, true, uint64(0))\n", cm, f.idx, s.checkerPkg(pidx), ri))
 			b.WriteString("  }\n")
 		}
 		b.WriteString("}\n")
 	}
 
-	b.WriteString(fmt.Sprintf("\n  %s.EndFcn(%d)\n", s.utilsPkg(), pidx))
+	if f.ptrrcvr {
+		s.emitReceiverPostCheck(f, b, pidx)
+	}
+	if f.mutates() {
+		s.emitMutateChecks(f, b, pidx)
+	}
+
+	b.WriteString("\n  ctx.EndFcn()\n")
 
 	b.WriteString("}\n\n")
 }
 
+// pickConversion decides whether the top-level string or byte slice
+// param or result 'p' is passed via a []byte -> string or string ->
+// []byte conversion respectively, according to the
+// stringViaBytesFraction tunable. Since the conversion makes a copy,
+// byte slices whose identity matters ('keep') aren't converted, and
+// neither are nil ones.
+func (s *genstate) pickConversion(p parm, keep bool) {
+	switch x := p.(type) {
+	case *stringparm:
+		x.viaBytes = uint8(s.wr.Intn(100)) < s.tunables.stringViaBytesFraction
+	case *arrayparm:
+		if isByteSlice(x) && !x.isnil && !keep {
+			x.viaString = uint8(s.wr.Intn(100)) < s.tunables.stringViaBytesFraction
+		}
+	}
+}
+
+// isByteSlice returns true if 'p' is a slice of bytes, which can be
+// converted to and from a string.
+func isByteSlice(p parm) bool {
+	ap, ok := p.(*arrayparm)
+	if !ok || !ap.slice {
+		return false
+	}
+	np, ok := ap.eltype.(*numparm)
+	return ok && (np.tag == "byte" || (np.tag == "uint" && np.widthInBits == 8))
+}
+
+// isViaBytes returns true if 'p' is a string param (or result) that
+// is passed via a []byte -> string conversion.
+func isViaBytes(p parm) bool {
+	sp, ok := p.(*stringparm)
+	return ok && sp.viaBytes
+}
+
+// isViaString returns true if 'p' is a byte slice param (or result)
+// that is passed via a string -> []byte conversion.
+func isViaString(p parm) bool {
+	ap, ok := p.(*arrayparm)
+	return ok && ap.viaString
+}
+
+// genCallArg returns the expression the caller passes for param 'p'.
+func (s *genstate) genCallArg(p parm, pi int) string {
+	if isViaBytes(p) {
+		return fmt.Sprintf("string(p%db)", pi)
+	}
+	if isViaString(p) {
+		return fmt.Sprintf("%s(p%ds)", p.QualName(), pi)
+	}
+	return fmt.Sprintf("p%d", pi)
+}
+
+// emitReturnConv emits the first half of the conversion (if any)
+// through which the checker returns 'val' for result 'r', and
+// returns the expression for the second half (or just 'val').
+func (s *genstate) emitReturnConv(b *bytes.Buffer, r parm, val string) string {
+	if isViaBytes(r) {
+		b.WriteString(fmt.Sprintf("  %sb := []byte(%s)\n", val, val))
+		return fmt.Sprintf("string(%sb)", val)
+	}
+	if isViaString(r) {
+		b.WriteString(fmt.Sprintf("  %ss := string(%s)\n", val, val))
+		return fmt.Sprintf("%s(%ss)", r.TypeName(), val)
+	}
+	return val
+}
+
+// emitReceiverPostCheck emits code in the caller to verify that the
+// new receiver value written by pointer method 'f' is visible once
+// the call completes.
+func (s *genstate) emitReceiverPostCheck(f *funcdef, b *bytes.Buffer, pidx int) {
+	b.WriteString("\n  // check receiver update made by pointer method\n")
+	if f.receiver.IsBlank() || isZeroSize(f.receiver) {
+		b.WriteString("  _ = rcvrpost\n")
+		return
+	}
+	pfc := "ctx.ParamFailCount == 0 && "
+	if f.receiver.HasPointer() {
+		efn := "!" + s.eqFuncRef(f, f.receiver, true)
+		b.WriteString(fmt.Sprintf("  if %s%s(rcvr, rcvrpost) {\n", pfc, efn))
+	} else {
+		b.WriteString(fmt.Sprintf("  if %srcvr != rcvrpost {\n", pfc))
+	}
+	cm := f.complexityMeasure()
+	b.WriteString(fmt.Sprintf("    ctx.NoteFailure(%d, %d, \"%s\", \"receiver\", 0, true, uint64(0))\n", cm, f.idx, s.checkerPkg(pidx)))
+	b.WriteString("  }\n")
+}
+
+// mutable returns true if 'p' is a pointer, slice or map param that
+// the checker can write new values through.
+func mutable(p parm) bool {
+	if p.IsBlank() || p.IsControl() {
+		return false
+	}
+	switch x := p.(type) {
+	case *pointerparm:
+		base, _ := genDeref(x.totype)
+		return base.NumElements() != 0
+	case *arrayparm:
+		return x.slice && x.nelements != 0 && x.eltype.NumElements() != 0
+	case *mapparm:
+		base, _ := genDeref(x.valtype)
+		return len(x.keytmps) != 0 && base.NumElements() != 0
+	}
+	return false
+}
+
+// mutates returns true if the checker for 'f' writes through any
+// of its params.
+func (f *funcdef) mutates() bool {
+	for _, m := range f.mutp {
+		if m {
+			return true
+		}
+	}
+	return false
+}
+
+// mutateTarget returns the type of the value written through param
+// 'p' by the checker, along with an expression (rooted at 'path')
+// that refers to the location written.
+func mutateTarget(p parm, path string) (parm, string) {
+	switch x := p.(type) {
+	case *pointerparm:
+		return x.totype, "*" + path
+	case *arrayparm:
+		return x, path
+	case *mapparm:
+		return x.valtype, fmt.Sprintf("%s[mkt.%s]", path, x.keytmps[0])
+	}
+	panic("unexpected param type in mutateTarget")
+}
+
+// genMutateValue generates the value that the checker writes through
+// param 'p'.
+func (s *genstate) genMutateValue(f *funcdef, p parm, value int, caller bool) (string, int) {
+	t, _ := mutateTarget(p, "")
+	return s.GenValue(f, t, value, caller)
+}
+
+// emitMutates emits code in the checker that writes the values in
+// 'vals' through the params of 'f' selected for mutation.
+func (s *genstate) emitMutates(f *funcdef, b *bytes.Buffer, vals []string) {
+	for pi, p := range f.params {
+		if !f.mutp[pi] {
+			continue
+		}
+		_, lhs := mutateTarget(p, s.genParamRef(p, pi))
+		if _, ok := p.(*arrayparm); ok {
+			b.WriteString(fmt.Sprintf("  copy(%s, %s)\n", lhs, vals[pi]))
+		} else {
+			b.WriteString(fmt.Sprintf("  %s = %s\n", lhs, vals[pi]))
+		}
+	}
+}
+
+// emitMutateChecks emits code in the caller to verify that writes
+// made by the checker through params of 'f' are visible after the
+// call.
+func (s *genstate) emitMutateChecks(f *funcdef, b *bytes.Buffer, pidx int) {
+	b.WriteString("\n  // check writes made through params\n")
+	cm := f.complexityMeasure()
+	for pi, p := range f.params {
+		if !f.mutp[pi] {
+			continue
+		}
+		t, lhs := mutateTarget(p, fmt.Sprintf("p%d", pi))
+		rhs := fmt.Sprintf("p%dpost", pi)
+		basep, star := genDeref(t)
+		pfc := "ctx.ParamFailCount == 0 && "
+		if basep.HasPointer() {
+			efn := "!" + s.eqFuncRef(f, basep, true)
+			b.WriteString(fmt.Sprintf("  if %s%s(%s%s, %s%s) {\n", pfc, efn, star, lhs, star, rhs))
+		} else {
+			b.WriteString(fmt.Sprintf("  if %s%s%s != %s%s {\n", pfc, star, lhs, star, rhs))
+		}
+		b.WriteString(fmt.Sprintf("    ctx.NoteFailure(%d, %d, \"%s\", \"mutate\", %d, true, uint64(0))\n", cm, f.idx, s.checkerPkg(pidx), pi))
+		b.WriteString("  }\n")
+	}
+}
+
+// Ways in which the caller can invoke a test method.
+const (
+	// rcvr.TestN(...)
+	mcallDirect = iota
+	// mv := rcvr.TestN; mv(...)
+	mcallMethodValue
+	// T.TestN(rcvr, ...)
+	mcallMethodExpr
+	// (*T).TestN(&rcvr, ...)
+	mcallPtrMethodExpr
+	// var itf I = rcvr; itf.TestN(...)
+	mcallInterface
+	// var itf I = &rcvr; itf.TestN(...)
+	mcallPtrInterface
+	// prcvr := &rcvr; prcvr.TestN(...)
+	mcallViaPointer
+	numMethodCallShapes
+)
+
+// emitMethodCallee emits any setup code needed to invoke the test
+// method 'f' on the caller's "rcvr" variable using the call shape
+// selected for 'f', then returns the callee expression to use,
+// along with an additional leading argument (if any).
+func (s *genstate) emitMethodCallee(f *funcdef, b *bytes.Buffer, pidx int) (string, string) {
+	switch f.mcall {
+	case mcallDirect:
+		return fmt.Sprintf("rcvr.Test%d", f.idx), ""
+	case mcallMethodValue:
+		b.WriteString(fmt.Sprintf("  mv := rcvr.Test%d\n", f.idx))
+		return "mv", ""
+	case mcallMethodExpr:
+		return fmt.Sprintf("%s.Test%d", f.receiver.QualName(), f.idx), "rcvr"
+	case mcallPtrMethodExpr:
+		return fmt.Sprintf("(*%s).Test%d", f.receiver.QualName(), f.idx), "&rcvr"
+	case mcallInterface, mcallPtrInterface:
+		amp := ""
+		if f.mcall == mcallPtrInterface {
+			amp = "&"
+		}
+		b.WriteString(fmt.Sprintf("  var itf %s.%s = %srcvr\n",
+			s.checkerPkg(pidx), s.itfName(f), amp))
+		return fmt.Sprintf("itf.Test%d", f.idx), ""
+	case mcallViaPointer:
+		b.WriteString("  prcvr := &rcvr\n")
+		return fmt.Sprintf("prcvr.Test%d", f.idx), ""
+	}
+	panic("bad method call shape")
+}
+
+// itfName returns the name of the interface type satisfied by the
+// receiver type of test method 'f'.
+func (s *genstate) itfName(f *funcdef) string {
+	return fmt.Sprintf("ItfF%d", f.idx)
+}
+
+// emitInterfaceDef emits an interface type containing the method
+// 'f', for use in interface method calls.
+func (s *genstate) emitInterfaceDef(f *funcdef, b *bytes.Buffer) {
+	b.WriteString(fmt.Sprintf("type %s interface {\n", s.itfName(f)))
+	b.WriteString(fmt.Sprintf("  Test%d(%s", f.idx, s.ctxParam()))
+	for pi, p := range f.params {
+		s.writeParamCom(b, pi)
+		p.Declare(b, fmt.Sprintf("p%d", pi), "", false)
+	}
+	b.WriteString(")")
+	if len(f.returns) > 0 {
+		b.WriteString(" (")
+		for ri, r := range f.returns {
+			writeCom(b, ri)
+			r.Declare(b, fmt.Sprintf("r%d", ri), "", false)
+		}
+		b.WriteString(")")
+	}
+	b.WriteString("\n}\n\n")
+}
+
 func checkableElements(p parm) int {
 	if p.IsBlank() {
 		return 0
@@ -1159,9 +2107,10 @@ func (s *genstate) emitGenValFuncs(f *funcdef, b *bytes.Buffer, emit bool) {
 			for _, cp := range contained {
 				mp, ismap := cp.(*mapparm)
 				if ismap {
-					b.WriteString(fmt.Sprintf("  %s := mkt.%s\n",
-						mp.keytmp, mp.keytmp))
-					b.WriteString(fmt.Sprintf("  _ = %s\n", mp.keytmp))
+					for _, kt := range mp.keytmps {
+						b.WriteString(fmt.Sprintf("  %s := mkt.%s\n", kt, kt))
+						b.WriteString(fmt.Sprintf("  _ = %s\n", kt))
+					}
 				}
 			}
 		}
@@ -1249,6 +2198,15 @@ func (s *genstate) genAllocFunc(p parm) string {
 	return f
 }
 
+// genReceiverRef returns an expression that refers to the receiver
+// value within the test method 'f'.
+func (s *genstate) genReceiverRef(f *funcdef) string {
+	if f.ptrrcvr {
+		return "(*rcvr)"
+	}
+	return "rcvr"
+}
+
 func (s *genstate) genParamRef(p parm, idx int) string {
 	switch p.AddrTaken() {
 	case notAddrTaken:
@@ -1277,6 +2235,49 @@ func (s *genstate) genReturnAssign(b *bytes.Buffer, r parm, idx int, val string)
 	}
 }
 
+// genReturnZero is the counterpart of genReturnAssign for results
+// that are deliberately left unassigned: it just marks the value
+// (and address, if any) as used.
+func genReturnZero(b *bytes.Buffer, r parm, idx int, val string) {
+	b.WriteString(fmt.Sprintf("  _ = %s // r%d left as zero value\n", val, idx))
+	if r.AddrTaken() != notAddrTaken {
+		b.WriteString(fmt.Sprintf("  _ = ar%d\n", idx))
+	}
+}
+
+// emitStringIdentityCheck emits code to verify that a string element
+// whose value is a substring of the shared global string still refers
+// to the global's data (as opposed to a copy of it).
+func (s *genstate) emitStringIdentityCheck(f *funcdef, b *bytes.Buffer, p parm, pvar string, cvar string, valstr string, paramidx int, elemidx int) {
+	if _, ok := p.(*stringparm); !ok || p.SkipCompare() != SkipNone {
+		return
+	}
+	if !strings.HasPrefix(valstr, s.utilsPkg()+".SharedStr[") {
+		return
+	}
+	b.WriteString(fmt.Sprintf("  if !%s.SameStringData(%s, %s) {\n", s.utilsPkg(), pvar, cvar))
+	cm := f.complexityMeasure()
+	b.WriteString(fmt.Sprintf("    ctx.NoteFailureElem(%d, %d, \"%s\", \"parm string data\", %d, %d, false, pad[0])\n", cm, f.idx, s.checkerPkg(s.pkidx), paramidx, elemidx))
+	b.WriteString("    return\n")
+	b.WriteString("  }\n")
+}
+
+// emitNilChecks emits code to verify that the slices and maps within
+// param 'p' (referenced via 'ref') have the expected nil-ness and
+// lengths. This has to happen before any element checks, since
+// an element check could otherwise index past the end of a slice.
+func (s *genstate) emitNilChecks(f *funcdef, b *bytes.Buffer, p parm, ref string, pi int) {
+	conds := nilChecks(p, ref)
+	if len(conds) == 0 {
+		return
+	}
+	b.WriteString(fmt.Sprintf("  if !(%s) {\n", strings.Join(conds, " &&\n    ")))
+	cm := f.complexityMeasure()
+	b.WriteString(fmt.Sprintf("    ctx.NoteFailure(%d, %d, \"%s\", \"nil/len\", %d, false, pad[0])\n", cm, f.idx, s.checkerPkg(s.pkidx), pi))
+	b.WriteString("    return\n")
+	b.WriteString("  }\n")
+}
+
 func (s *genstate) emitParamElemCheck(f *funcdef, b *bytes.Buffer, p parm, pvar string, cvar string, paramidx int, elemidx int) {
 	if p.SkipCompare() == SkipAll {
 		b.WriteString(fmt.Sprintf("  // selective skip of %s\n", pvar))
@@ -1306,7 +2307,7 @@ func (s *genstate) emitParamElemCheck(f *funcdef, b *bytes.Buffer, p parm, pvar
 		}
 	}
 	cm := f.complexityMeasure()
-	b.WriteString(fmt.Sprintf("    %s.NoteFailureElem(%d, %d, %d, \"%s\", \"parm\", %d, %d, false, pad[0])\n", s.utilsPkg(), cm, s.pkidx, f.idx, s.checkerPkg(s.pkidx), paramidx, elemidx))
+	b.WriteString(fmt.Sprintf("    ctx.NoteFailureElem(%d, %d, \"%s\", \"parm\", %d, %d, false, pad[0])\n", cm, f.idx, s.checkerPkg(s.pkidx), paramidx, elemidx))
 	b.WriteString("    return\n")
 	b.WriteString("  }\n")
 }
@@ -1318,9 +2319,17 @@ func (s *genstate) emitParamChecks(f *funcdef, b *bytes.Buffer, pidx int, value
 	for pi, p := range f.params {
 		verb(4, "emitting parmcheck p%d numel=%d pt=%s value=%d",
 			pi, p.NumElements(), p.TypeName(), value)
-		// To balance code in caller
-		_ = uint8(s.wr.Intn(100)) < 50
-		if p.IsControl() {
+		m := f.alias.member(pi)
+		if m == nil {
+			// To balance code in caller
+			_ = uint8(s.wr.Intn(100)) < 50
+		}
+		if m != nil {
+			s.emitAliasMemberChecks(f, b, m)
+			if p.AddrTaken() != notAddrTaken {
+				dangling = append(dangling, pi)
+			}
+		} else if p.IsControl() {
 			b.WriteString(fmt.Sprintf("  if %s == 0 {\n",
 				s.genParamRef(p, pi)))
 			s.emitReturn(f, b, false)
@@ -1335,6 +2344,7 @@ func (s *genstate) emitParamChecks(f *funcdef, b *bytes.Buffer, pidx int, value
 				b.WriteString(fmt.Sprintf("  _ = %s\n", valstr))
 			}
 		} else {
+			s.emitNilChecks(f, b, p, s.genParamRef(p, pi), pi)
 			numel := p.NumElements()
 			cel := checkableElements(p)
 			for i := 0; i < numel; i++ {
@@ -1353,6 +2363,9 @@ func (s *genstate) emitParamChecks(f *funcdef, b *bytes.Buffer, pidx int, value
 					cvar := fmt.Sprintf("p%df%dc", pi, i)
 					b.WriteString(fmt.Sprintf("  %s := %s\n", cvar, valstr))
 					s.emitParamElemCheck(f, b, elparm, elref, cvar, pi, i)
+					if !isViaBytes(p) {
+						s.emitStringIdentityCheck(f, b, elparm, elref, cvar, valstr, pi, i)
+					}
 				}
 			}
 			if p.AddrTaken() != notAddrTaken {
@@ -1363,17 +2376,22 @@ func (s *genstate) emitParamChecks(f *funcdef, b *bytes.Buffer, pidx int, value
 			fmt.Fprintf(os.Stderr, "internal error: checker/caller value mismatch after emitting param %d func Test%d pkg %s: caller %d checker %d\n", pi, f.idx, s.checkerPkg(pidx), f.values[pi], value)
 			s.errs++
 		}
+		s.emitGCPoint(f, b, pi+1)
 	}
 	for _, pi := range dangling {
 		b.WriteString(fmt.Sprintf("  _ = ap%d // ref\n", pi))
 	}
+	s.emitAliasIdentityChecks(f, b)
 
 	// receiver value check
 	if f.method {
+		if !f.receiver.IsBlank() {
+			s.emitNilChecks(f, b, f.receiver, s.genReceiverRef(f), -1)
+		}
 		numel := f.receiver.NumElements()
 		for i := 0; i < numel; i++ {
 			verb(4, "emitting check-code for rcvr el %d value=%d", i, value)
-			elref, elparm := f.receiver.GenElemRef(i, "rcvr")
+			elref, elparm := f.receiver.GenElemRef(i, s.genReceiverRef(f))
 			valstr, value = s.GenValue(f, elparm, value, false)
 			if elref == "" || strings.HasPrefix(elref, "_") || f.receiver.IsBlank() {
 				verb(4, "empty skip rcvr el %d", i)
@@ -1418,10 +2436,62 @@ func (s *genstate) emitDeferChecks(f *funcdef, b *bytes.Buffer, pidx int, value
 		passed = append(passed, p)
 	}
 
-	b.WriteString("  defer func(")
+	// Params written through by the checker are left out, since
+	// the deferred checks run after the writes.
+	b.WriteString("  defer ")
+	s.emitClosureChecks(f, b, passed, f.mutp, "")
+	b.WriteString("\n")
+
+	return value
+}
+
+// emitGoChecks creates code like
+//
+//     var wg sync.WaitGroup
+//     wg.Add(1)
+//     go func(...args...) {
+//       defer wg.Done()
+//       check arg
+//       check param
+//     }(...)
+//     wg.Wait()
+//
+// which is similar to what emitDeferChecks does, but with the checks
+// being performed on a new goroutine (whose arguments are copied
+// via a different path than those of a deferred call).
+func (s *genstate) emitGoChecks(f *funcdef, b *bytes.Buffer) {
+
+	if len(f.params) == 0 {
+		return
+	}
+
+	passed := []bool{}
+	for i := range f.params {
+		passed = append(passed, f.dogop[i] < 50)
+	}
+
+	b.WriteString("  var wg sync.WaitGroup\n")
+	b.WriteString("  wg.Add(1)\n")
+	b.WriteString("  go ")
+	// Shadow 'pad' within the goroutine, since capturing it would
+	// force the original onto the heap.
+	s.emitClosureChecks(f, b, passed, nil, "  defer wg.Done()\n  var pad [1]uint64\n  _ = pad\n")
+	b.WriteString("  wg.Wait()\n\n")
+}
+
+// emitClosureChecks emits a function literal (plus the arguments in
+// a call to it) that checks the values of the params of 'f', either
+// passed to the literal as arguments or captured, according to
+// 'passed'. Params selected by 'skip' (if non-nil) are left out. The
+// literal body begins with 'prologue'.
+func (s *genstate) emitClosureChecks(f *funcdef, b *bytes.Buffer, passed []bool, skip []bool, prologue string) {
+	omit := func(pi int, p parm) bool {
+		return p.IsControl() || p.IsBlank() || (skip != nil && skip[pi])
+	}
+	b.WriteString("func(")
 	pc := 0
 	for pi, p := range f.params {
-		if p.IsControl() || p.IsBlank() {
+		if omit(pi, p) {
 			continue
 		}
 		if passed[pi] {
@@ -1432,9 +2502,10 @@ func (s *genstate) emitDeferChecks(f *funcdef, b *bytes.Buffer, pidx int, value
 		}
 	}
 	b.WriteString(") {\n")
+	b.WriteString(prologue)
 
 	for pi, p := range f.params {
-		if p.IsControl() || p.IsBlank() {
+		if omit(pi, p) {
 			continue
 		}
 		which := "passed"
@@ -1463,7 +2534,7 @@ func (s *genstate) emitDeferChecks(f *funcdef, b *bytes.Buffer, pidx int, value
 	b.WriteString("  } (")
 	pc = 0
 	for pi, p := range f.params {
-		if p.IsControl() || p.IsBlank() {
+		if omit(pi, p) {
 			continue
 		}
 		if passed[pi] {
@@ -1472,20 +2543,20 @@ func (s *genstate) emitDeferChecks(f *funcdef, b *bytes.Buffer, pidx int, value
 			pc++
 		}
 	}
-	b.WriteString(")\n\n")
-
-	return value
+	b.WriteString(")\n")
 }
 
 func (s *genstate) emitVarAssign(f *funcdef, b *bytes.Buffer, r parm, rname string, value int, caller bool) int {
 	var valstr string
 	isassign := uint8(s.wr.Intn(100)) < 50
-	if rmp, ismap := r.(*mapparm); ismap && isassign {
+	if rmp, ismap := r.(*mapparm); ismap && isassign && len(rmp.keytmps) != 0 {
 		// emit: var m ... ; m[k] = v
 		r.Declare(b, "  "+rname+" := make(", ")\n", caller)
-		valstr, value = s.GenValue(f, rmp.valtype, value, caller)
-		b.WriteString(fmt.Sprintf("  %s[mkt.%s] = %s\n",
-			rname, rmp.keytmp, valstr))
+		for _, kt := range rmp.keytmps {
+			valstr, value = s.GenValue(f, rmp.valtype, value, caller)
+			b.WriteString(fmt.Sprintf("  %s[mkt.%s] = %s\n",
+				rname, kt, valstr))
+		}
 	} else {
 		// emit r = c
 		valstr, value = s.GenValue(f, r, value, caller)
@@ -1497,7 +2568,17 @@ func (s *genstate) emitVarAssign(f *funcdef, b *bytes.Buffer, r parm, rname stri
 func (s *genstate) emitChecker(f *funcdef, b *bytes.Buffer, pidx int, emit bool) {
 	verb(4, "emitting struct and array defs")
 	s.emitStructAndArrayDefs(f, b)
+	if f.method && (f.mcall == mcallInterface || f.mcall == mcallPtrInterface) {
+		s.emitInterfaceDef(f, b)
+	}
+	if !s.ctxInParams() {
+		b.WriteString(fmt.Sprintf("// %s is the context of the call to Test%d in progress.\n", s.ctxVar(f), f.idx))
+		b.WriteString(fmt.Sprintf("var %s *%s.TestCtx\n\n", s.ctxVar(f), s.utilsPkg()))
+	}
 	b.WriteString(fmt.Sprintf("// %d returns %d params\n", len(f.returns), len(f.params)))
+	if f.regboundary {
+		s.emitRegComment(f, b)
+	}
 	if s.pragma != "" {
 		b.WriteString("//go:" + s.pragma + "\n")
 	}
@@ -1511,17 +2592,20 @@ func (s *genstate) emitChecker(f *funcdef, b *bytes.Buffer, pidx int, emit bool)
 		if f.receiver.IsBlank() {
 			n = "_"
 		}
+		if f.ptrrcvr {
+			n += " *"
+		}
 		f.receiver.Declare(b, n, "", false)
 		b.WriteString(")")
 	}
 
-	b.WriteString(fmt.Sprintf(" Test%d(", f.idx))
+	b.WriteString(fmt.Sprintf(" Test%d(%s", f.idx, s.ctxParam()))
 
 	verb(4, "emitting checker p%d/Test%d", pidx, f.idx)
 
 	// params
 	for pi, p := range f.params {
-		writeCom(b, pi)
+		s.writeParamCom(b, pi)
 		n := fmt.Sprintf("p%d", pi)
 		if p.IsBlank() {
 			n = "_"
@@ -1543,10 +2627,14 @@ func (s *genstate) emitChecker(f *funcdef, b *bytes.Buffer, pidx int, emit bool)
 	}
 	b.WriteString(" {\n")
 
+	if !s.ctxInParams() {
+		b.WriteString(fmt.Sprintf("  ctx := %s\n", s.ctxVar(f)))
+	}
+
 	// local storage
 	b.WriteString("  // consume some stack space, so as to trigger morestack\n")
 	b.WriteString(fmt.Sprintf("  var pad [%d]uint64\n", f.rstack))
-	b.WriteString(fmt.Sprintf("  pad[%s.FailCount[%d] & 0x1]++\n", s.utilsPkg(), pidx))
+	b.WriteString("  pad[ctx.ParamFailCount & 0x1]++\n")
 
 	value := 1
 
@@ -1580,19 +2668,61 @@ func (s *genstate) emitChecker(f *funcdef, b *bytes.Buffer, pidx int, emit bool)
 		}
 	}
 
+	s.emitGCPoint(f, b, 0)
+
+	// expected contents of backing array for aliased slice params
+	value = s.emitAliasBacking(f, b, value, false)
+
 	// parameter checking code
 	var haveControl bool
 	s.wr.Checkpoint("before param checks")
 	value, haveControl = s.emitParamChecks(f, b, pidx, value)
 
+	// new receiver value for pointer methods, written just before
+	// returning
+	rcvrpost := ""
+	if f.ptrrcvr {
+		rcvrpost, value = s.GenValue(f, f.receiver, value, false)
+	}
+
+	// new values to write through params, also written just before
+	// returning
+	mutvals := make([]string, len(f.params))
+	if f.mutates() {
+		s.wr.Checkpoint("before mutate values")
+		for pi, p := range f.params {
+			if f.mutp[pi] {
+				mutvals[pi], value = s.genMutateValue(f, p, value, false)
+			}
+		}
+	}
+
 	// defer testing
 	if s.tunables.doDefer && f.dodefc < s.tunables.deferFraction {
 		s.wr.Checkpoint("before defer checks")
 		_ = s.emitDeferChecks(f, b, pidx, value)
 	}
 
+	// go statement testing
+	if s.tunables.doGo && f.dogoc < s.tunables.goFraction {
+		s.wr.Checkpoint("before go checks")
+		s.emitGoChecks(f, b)
+	}
+
+	if f.ptrrcvr && !f.receiver.IsBlank() {
+		b.WriteString(fmt.Sprintf("  *rcvr = %s\n", rcvrpost))
+	}
+	if f.mutates() {
+		s.emitMutates(f, b, mutvals)
+	}
+
 	// returns
-	s.emitReturn(f, b, haveControl)
+	s.emitGCPoint(f, b, len(f.params)+1)
+	if f.dopanic {
+		s.emitPanicReturn(f, b)
+	} else {
+		s.emitReturn(f, b, haveControl)
+	}
 
 	b.WriteString(fmt.Sprintf("  // %d addr-taken params, %d addr-taken returns\n",
 		aCounts[0], aCounts[1]))
@@ -1603,6 +2733,50 @@ func (s *genstate) emitChecker(f *funcdef, b *bytes.Buffer, pidx int, emit bool)
 	s.emitAddrTakenHelpers(f, b, emit)
 }
 
+// emitGCPoint emits a call to runtime.GC() if we've elected to inject
+// a GC at injection point 'pt' within the test function 'f'.
+func (s *genstate) emitGCPoint(f *funcdef, b *bytes.Buffer, pt int) {
+	if pt < len(f.gcpoints) && f.gcpoints[pt] {
+		b.WriteString("  runtime.GC() // injected\n")
+	}
+}
+
+// emitPanicReturn generates code like
+//
+//	defer func() {
+//	  pv := recover()
+//	  check pv
+//	  r0 = rc0
+//	  ...
+//	}()
+//	panic(...)
+//
+// in place of a regular return sequence, so that results reach the
+// caller only via writes to the named results in the deferred func.
+func (s *genstate) emitPanicReturn(f *funcdef, b *bytes.Buffer) {
+	b.WriteString("  defer func() {\n")
+	b.WriteString("  pv := recover()\n")
+	b.WriteString("  if pv == nil {\n")
+	b.WriteString("    return\n")
+	b.WriteString("  }\n")
+	b.WriteString("  if pvv, ok := pv.(")
+	f.panicparm.Declare(b, "", "", false)
+	b.WriteString(fmt.Sprintf("); !ok || pvv != %s {\n", f.panicval))
+	cm := f.complexityMeasure()
+	b.WriteString(fmt.Sprintf("    ctx.NoteFailure(%d, %d, \"%s\", \"panic\", 0, false, pad[0])\n", cm, f.idx, s.checkerPkg(s.pkidx)))
+	b.WriteString("  }\n")
+	for ri, r := range f.returns {
+		val := s.emitReturnConv(b, r, fmt.Sprintf("rc%d", ri))
+		if f.retZero(ri) {
+			genReturnZero(b, r, ri, val)
+			continue
+		}
+		s.genReturnAssign(b, r, ri, val)
+	}
+	b.WriteString("  }()\n")
+	b.WriteString(fmt.Sprintf("  panic(%s)\n", f.panicval))
+}
+
 // complexityMeasure returns an integer that estimates how complex a given test function
 // is relative to some other function. The more parameters + returns and the more complicated
 // the types of the params/returns, the higher the number returned here.
@@ -1628,8 +2802,13 @@ func (s *genstate) emitRecursiveCall(f *funcdef) string {
 		rcvr = "rcvr."
 	}
 	b.WriteString(fmt.Sprintf(" %sTest%d(", rcvr, f.idx))
+	if s.ctxInParams() {
+		b.WriteString("ctx")
+	}
 	for pi, p := range f.params {
-		writeCom(b, pi)
+		if pi != 0 || s.ctxInParams() {
+			b.WriteString(",")
+		}
 		if p.IsControl() {
 			b.WriteString(fmt.Sprintf(" %s-1", s.genParamRef(p, pi)))
 		} else {
@@ -1646,7 +2825,8 @@ func (s *genstate) emitRecursiveCall(f *funcdef) string {
 
 // emitReturn generates a return sequence.
 func (s *genstate) emitReturn(f *funcdef, b *bytes.Buffer, doRecursiveCall bool) {
-	// If any of the return values are address-taken, then instead of
+	// If any of the return values are address-taken, or if we've
+	// selected one of the named result styles, then instead of
 	//
 	//   return x, y, z
 	//
@@ -1657,9 +2837,10 @@ func (s *genstate) emitReturn(f *funcdef, b *bytes.Buffer, doRecursiveCall bool)
 	//   ...
 	//   return
 	//
-	// Make an initial pass through the returns to see if we need to do this.
-	// Figure out the final return values in the process.
-	indirectReturn := false
+	// possibly leaving some of the results unassigned. Make an
+	// initial pass through the returns to see if we need to do
+	// this. Figure out the final return values in the process.
+	indirectReturn := f.retstyle != retExplicit && len(f.returns) != 0
 	retvals := []string{}
 	for ri, r := range f.returns {
 		if r.AddrTaken() != notAddrTaken {
@@ -1698,8 +2879,17 @@ func (s *genstate) emitReturn(f *funcdef, b *bytes.Buffer, doRecursiveCall bool)
 	}
 
 	// now the actual return
+	if !doRecursiveCall {
+		for ri, r := range f.returns {
+			retvals[ri] = s.emitReturnConv(b, r, retvals[ri])
+		}
+	}
 	if indirectReturn {
 		for ri, r := range f.returns {
+			if f.retZero(ri) {
+				genReturnZero(b, r, ri, retvals[ri])
+				continue
+			}
 			s.genReturnAssign(b, r, ri, retvals[ri])
 		}
 		b.WriteString("  return\n")
@@ -1713,7 +2903,7 @@ func (s *genstate) emitReturn(f *funcdef, b *bytes.Buffer, doRecursiveCall bool)
 	}
 }
 
-func (s *genstate) GenPair(calloutfile *os.File, checkoutfile *os.File, fidx int, pidx int, b *bytes.Buffer, seed int64, emit bool) int64 {
+func (s *genstate) GenPair(calloutfile *genFile, checkoutfile *genFile, fidx int, pidx int, b *bytes.Buffer, seed int64, emit bool) {
 
 	verb(1, "gen fidx %d pidx %d", fidx, pidx)
 
@@ -1724,6 +2914,7 @@ func (s *genstate) GenPair(calloutfile *os.File, checkoutfile *os.File, fidx int
 	s.wr = NewWrapRand(seed, s.randctl)
 	s.wr.tag = "genfunc"
 	fp := s.GenFunc(fidx, pidx)
+	s.stats.noteZeroSize(fp)
 
 	// Emit caller side
 	wrcaller := NewWrapRand(seed, s.randctl)
@@ -1731,7 +2922,7 @@ func (s *genstate) GenPair(calloutfile *os.File, checkoutfile *os.File, fidx int
 	s.wr.tag = "caller"
 	s.emitCaller(fp, b, pidx)
 	if emit {
-		b.WriteTo(calloutfile)
+		calloutfile.writeFunc(b, fidx, seed)
 	}
 	b.Reset()
 
@@ -1741,129 +2932,260 @@ func (s *genstate) GenPair(calloutfile *os.File, checkoutfile *os.File, fidx int
 	s.wr.tag = "checker"
 	s.emitChecker(fp, b, pidx, emit)
 	if emit {
-		b.WriteTo(checkoutfile)
+		checkoutfile.writeFunc(b, fidx, seed)
 	}
 	b.Reset()
 	wrchecker.Check(wrcaller)
 
-	return seed + 1
+	if emit {
+		s.funcs = append(s.funcs, s.manifestFunc(fp, pidx, seed))
+	}
 }
 
-func (s *genstate) openOutputFile(filename string, pk string, imports []string, ipref string) *os.File {
-	verb(1, "opening %s", filename)
-	outf, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
-	if err != nil {
-		log.Fatal(err)
-	}
+// openOutputFile starts a new generated source file, emitting the
+// package clause and imports. The contents are buffered until
+// closeOutputFile is called.
+func (s *genstate) openOutputFile(filename string, pk string, imports []string, ipref string) *genFile {
+	outf := &genFile{name: filename}
 	haveunsafe := false
-	outf.WriteString(fmt.Sprintf("package %s\n\n", pk))
+	io.WriteString(outf, fmt.Sprintf("package %s\n\n", pk))
 	for _, imp := range imports {
-		if imp == "reflect" {
-			outf.WriteString("import \"reflect\"\n")
+		if imp == "reflect" || imp == "runtime" || imp == "sync" {
+			io.WriteString(outf, fmt.Sprintf("import \"%s\"\n", imp))
 			continue
 		}
 		if imp == "unsafe" {
-			outf.WriteString("import _ \"unsafe\"\n")
+			io.WriteString(outf, "import _ \"unsafe\"\n")
 			haveunsafe = true
 			continue
 		}
-		outf.WriteString(fmt.Sprintf("import \"%s%s\"\n", ipref, imp))
+		io.WriteString(outf, fmt.Sprintf("import \"%s%s\"\n", ipref, imp))
 	}
-	outf.WriteString("\n")
+	io.WriteString(outf, "\n")
 	if s.sforce && haveunsafe {
-		outf.WriteString("// Hack: reach into runtime to grab this testing hook.\n")
-		outf.WriteString("//go:linkname hackStack runtime.gcTestMoveStackOnNextCall\n")
-		outf.WriteString("func hackStack()\n\n")
+		io.WriteString(outf, "// Hack: reach into runtime to grab this testing hook.\n")
+		io.WriteString(outf, "//go:linkname hackStack runtime.gcTestMoveStackOnNextCall\n")
+		io.WriteString(outf, "func hackStack()\n\n")
 	}
 	return outf
 }
 
-func emitUtils(outf *os.File, maxfail int, numtpk int) {
+// closeOutputFile formats the contents of 'gf' (checking in the
+// process that it parses) and writes the result to the output.
+func (s *genstate) closeOutputFile(gf *genFile) {
+	src, nerrs := gf.format()
+	s.errs += nerrs
+	if s.typecheck {
+		gf.src = src
+		s.files = append(s.files, gf)
+	}
+	outf, err := s.out.Create(gf.name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := outf.Write(src); err != nil {
+		log.Fatal(err)
+	}
+	if err := outf.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// genGoVersion is the Go language version declared by the go.mod of
+// the generated module, and used when type-checking it. The utils
+// package needs at least 1.20 for unsafe.StringData.
+const genGoVersion = "1.20"
+
+func emitUtils(outf io.Writer, maxfail int, numtpk int) {
 	countfail := `
   if isret {
-    if ParamFailCount[pidx] != 0 {
+    if c.ParamFailCount != 0 {
       return
     }
-    ReturnFailCount[pidx]++
+    c.ReturnFailCount++
   } else {
-    ParamFailCount[pidx]++
+    c.ParamFailCount++
   }
 `
 	earlyexit := fmt.Sprintf(`
-  if (ParamFailCount[pidx] + FailCount[pidx] + ReturnFailCount[pidx] > %d) {
+  if (c.ParamFailCount + c.ReturnFailCount + int(atomic.LoadInt64(&FailCount[c.Pidx])) > %d) {
     os.Exit(1)
   }
 `, maxfail)
 
 	fmt.Fprintf(outf, "import \"fmt\"\n")
-	fmt.Fprintf(outf, "import \"os\"\n\n")
-	fmt.Fprintf(outf, "type UtilsType int\n")
-	fmt.Fprintf(outf, "var ParamFailCount[%d] int\n", numtpk)
-	fmt.Fprintf(outf, "var ReturnFailCount[%d] int\n", numtpk)
-	fmt.Fprintf(outf, "var FailCount[%d] int\n\n", numtpk)
-	fmt.Fprintf(outf, "var Mode[%d] string\n\n", numtpk)
+	fmt.Fprintf(outf, "import \"os\"\n")
+	fmt.Fprintf(outf, "import \"strings\"\n")
+	fmt.Fprintf(outf, "import \"sync/atomic\"\n")
+	fmt.Fprintf(outf, "import \"unsafe\"\n\n")
+	fmt.Fprintf(outf, "type UtilsType int\n\n")
+	fmt.Fprintf(outf, "// SharedStr is a global whose substrings are used as string values.\n")
+	fmt.Fprintf(outf, "var SharedStr = strings.Repeat(\"0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ!?\", %d)\n\n", sharedStrLen/64)
+	fmt.Fprintf(outf, "func RepeatStr(s string, n int) string {\n")
+	fmt.Fprintf(outf, "  return strings.Repeat(s, n)\n")
+	fmt.Fprintf(outf, "}\n\n")
+	fmt.Fprintf(outf, "// SameStringData returns true if a and b refer to the same bytes.\n")
+	fmt.Fprintf(outf, "func SameStringData(a, b string) bool {\n")
+	fmt.Fprintf(outf, "  return len(a) == len(b) && unsafe.StringData(a) == unsafe.StringData(b)\n")
+	fmt.Fprintf(outf, "}\n\n")
+	fmt.Fprintf(outf, "// TestCtx holds failure state for a single CallerN invocation.\n")
+	fmt.Fprintf(outf, "type TestCtx struct {\n")
+	fmt.Fprintf(outf, "  Pidx int\n")
+	fmt.Fprintf(outf, "  ParamFailCount int\n")
+	fmt.Fprintf(outf, "  ReturnFailCount int\n")
+	fmt.Fprintf(outf, "  Mode string\n")
+	fmt.Fprintf(outf, "}\n\n")
+	fmt.Fprintf(outf, "// per-package failure totals, updated atomically\n")
+	fmt.Fprintf(outf, "var FailCount[%d] int64\n\n", numtpk)
+	fmt.Fprintf(outf, "func NewCtx(pidx int) *TestCtx {\n")
+	fmt.Fprintf(outf, "  return &TestCtx{Pidx: pidx}\n")
+	fmt.Fprintf(outf, "}\n\n")
+	fmt.Fprintf(outf, "func TotalFailCount() int64 {\n")
+	fmt.Fprintf(outf, "  tf := int64(0)\n")
+	fmt.Fprintf(outf, "  for pidx := range FailCount {\n")
+	fmt.Fprintf(outf, "    tf += atomic.LoadInt64(&FailCount[pidx])\n")
+	fmt.Fprintf(outf, "  }\n")
+	fmt.Fprintf(outf, "  return tf\n")
+	fmt.Fprintf(outf, "}\n\n")
 	fmt.Fprintf(outf, "//go:noinline\n")
-	fmt.Fprintf(outf, "func NoteFailure(cm int, pidx int, fidx int, pkg string, pref string, parmNo int, isret bool,_ uint64) {")
-	outf.WriteString(countfail)
+	fmt.Fprintf(outf, "func (c *TestCtx) NoteFailure(cm int, fidx int, pkg string, pref string, parmNo int, isret bool,_ uint64) {")
+	io.WriteString(outf, countfail)
 	fmt.Fprintf(outf, "  fmt.Fprintf(os.Stderr, ")
-	fmt.Fprintf(outf, "\"Error: fail %%s |%%d|%%d|%%d| =%%s.Test%%d= %%s %%d\\n\", Mode, cm, pidx, fidx, pkg, fidx, pref, parmNo)\n")
-	outf.WriteString(earlyexit)
+	fmt.Fprintf(outf, "\"Error: fail %%s |%%d|%%d|%%d| =%%s.Test%%d= %%s %%d\\n\", c.Mode, cm, c.Pidx, fidx, pkg, fidx, pref, parmNo)\n")
+	io.WriteString(outf, earlyexit)
 	fmt.Fprintf(outf, "}\n\n")
 	fmt.Fprintf(outf, "//go:noinline\n")
-	fmt.Fprintf(outf, "func NoteFailureElem(cm int, pidx int, fidx int, pkg string, pref string, parmNo int, elem int, isret bool, _ uint64) {\n")
-	outf.WriteString(countfail)
+	fmt.Fprintf(outf, "func (c *TestCtx) NoteFailureElem(cm int, fidx int, pkg string, pref string, parmNo int, elem int, isret bool, _ uint64) {\n")
+	io.WriteString(outf, countfail)
 	fmt.Fprintf(outf, "  fmt.Fprintf(os.Stderr, ")
-	fmt.Fprintf(outf, "\"Error: fail %%s |%%d|%%d|%%d| =%%s.Test%%d= %%s %%d elem %%d\\n\", Mode, cm, pidx, fidx, pkg, fidx, pref, parmNo, elem)\n")
-	outf.WriteString(earlyexit)
+	fmt.Fprintf(outf, "\"Error: fail %%s |%%d|%%d|%%d| =%%s.Test%%d= %%s %%d elem %%d\\n\", c.Mode, cm, c.Pidx, fidx, pkg, fidx, pref, parmNo, elem)\n")
+	io.WriteString(outf, earlyexit)
 	fmt.Fprintf(outf, "}\n\n")
-	fmt.Fprintf(outf, "func BeginFcn(p int) {\n")
-	fmt.Fprintf(outf, "  ParamFailCount[p] = 0\n")
-	fmt.Fprintf(outf, "  ReturnFailCount[p] = 0\n")
+	fmt.Fprintf(outf, "func (c *TestCtx) BeginFcn() {\n")
+	fmt.Fprintf(outf, "  c.ParamFailCount = 0\n")
+	fmt.Fprintf(outf, "  c.ReturnFailCount = 0\n")
 	fmt.Fprintf(outf, "}\n\n")
-	fmt.Fprintf(outf, "func EndFcn(p int) {\n")
-	fmt.Fprintf(outf, "  FailCount[p] += ParamFailCount[p]\n")
-	fmt.Fprintf(outf, "  FailCount[p] += ReturnFailCount[p]\n")
+	fmt.Fprintf(outf, "func (c *TestCtx) EndFcn() {\n")
+	fmt.Fprintf(outf, "  atomic.AddInt64(&FailCount[c.Pidx], int64(c.ParamFailCount + c.ReturnFailCount))\n")
 	fmt.Fprintf(outf, "}\n\n")
 }
 
-func (s *genstate) emitMain(outf *os.File, numit int, fcnmask map[int]int, pkmask map[int]int, numtpk int) {
+// emitMain emits the main routine, which invokes each of the
+// CallerN functions in turn. By default each test package gets its
+// own goroutine; if s.testpar is nonzero, then each CallerN runs in
+// a separate goroutine, with at most s.testpar of them active at once.
+func (s *genstate) emitMain(outf io.Writer, numit int, fcnmask map[int]int, pkmask map[int]int, numtpk int) {
 	fmt.Fprintf(outf, "import \"fmt\"\n")
-	fmt.Fprintf(outf, "import \"os\"\n\n")
+	fmt.Fprintf(outf, "import \"os\"\n")
+	if s.testpar != 0 {
+		fmt.Fprintf(outf, "import \"sync\"\n")
+	}
+	if s.repeat > 1 {
+		if s.rhook != "freeosmem" {
+			fmt.Fprintf(outf, "import \"runtime\"\n")
+		}
+		if s.rhook == "freeosmem" || s.rhook == "cycle" {
+			fmt.Fprintf(outf, "import \"runtime/debug\"\n")
+		}
+	}
+	fmt.Fprintf(outf, "\n")
 	fmt.Fprintf(outf, "func main() {\n")
 	fmt.Fprintf(outf, "  fmt.Fprintf(os.Stderr, \"starting main\\n\")\n")
-	fmt.Fprintf(outf, "  pch := make(chan bool, %d)\n", numtpk)
-	for k := 0; k < s.numtpk; k++ {
-		cp := fmt.Sprintf("%sCaller%d", s.tag, k)
-		fmt.Fprintf(outf, "  go func(ch chan bool) {\n")
-		for i := 0; i < numit; i++ {
-			if emitFP(i, k, fcnmask, pkmask) {
-				fmt.Fprintf(outf, "    %s.Caller%d(\"normal\")\n", cp, i)
-				if s.tunables.doReflectCall {
-					fmt.Fprintf(outf, "    %s.Caller%d(\"reflect\")\n", cp, i)
+	if s.repeat > 1 {
+		fmt.Fprintf(outf, "  for iter := 0; iter < %d; iter++ {\n", s.repeat)
+	}
+	modes := []string{"normal"}
+	if s.tunables.doReflectCall {
+		modes = append(modes, "reflect")
+	}
+	if s.testpar != 0 {
+		fmt.Fprintf(outf, "  type testEntry struct {\n")
+		fmt.Fprintf(outf, "    pidx int\n")
+		fmt.Fprintf(outf, "    fn func(*%s.TestCtx, string)\n", s.utilsPkg())
+		fmt.Fprintf(outf, "  }\n")
+		fmt.Fprintf(outf, "  tests := []testEntry{\n")
+		for k := 0; k < s.numtpk; k++ {
+			for i := 0; i < numit; i++ {
+				if emitFP(i, k, fcnmask, pkmask) {
+					fmt.Fprintf(outf, "    {%d, %s.Caller%d},\n", k, s.callerPkg(k), i)
 				}
 			}
 		}
-		fmt.Fprintf(outf, "    pch <- true\n")
-		fmt.Fprintf(outf, "  }(pch)\n")
+		fmt.Fprintf(outf, "  }\n")
+		fmt.Fprintf(outf, "  sem := make(chan bool, %d)\n", s.testpar)
+		fmt.Fprintf(outf, "  var wg sync.WaitGroup\n")
+		fmt.Fprintf(outf, "  for _, t := range tests {\n")
+		fmt.Fprintf(outf, "    sem <- true\n")
+		fmt.Fprintf(outf, "    wg.Add(1)\n")
+		fmt.Fprintf(outf, "    go func(t testEntry) {\n")
+		fmt.Fprintf(outf, "      ctx := %s.NewCtx(t.pidx)\n", s.utilsPkg())
+		for _, m := range modes {
+			fmt.Fprintf(outf, "      t.fn(ctx, \"%s\")\n", m)
+		}
+		fmt.Fprintf(outf, "      <-sem\n")
+		fmt.Fprintf(outf, "      wg.Done()\n")
+		fmt.Fprintf(outf, "    }(t)\n")
+		fmt.Fprintf(outf, "  }\n")
+		fmt.Fprintf(outf, "  wg.Wait()\n")
+	} else {
+		fmt.Fprintf(outf, "  pch := make(chan bool, %d)\n", numtpk)
+		for k := 0; k < s.numtpk; k++ {
+			cp := s.callerPkg(k)
+			fmt.Fprintf(outf, "  go func(ch chan bool) {\n")
+			fmt.Fprintf(outf, "    ctx := %s.NewCtx(%d)\n", s.utilsPkg(), k)
+			for i := 0; i < numit; i++ {
+				if emitFP(i, k, fcnmask, pkmask) {
+					for _, m := range modes {
+						fmt.Fprintf(outf, "    %s.Caller%d(ctx, \"%s\")\n", cp, i, m)
+					}
+				}
+			}
+			fmt.Fprintf(outf, "    _ = ctx\n")
+			fmt.Fprintf(outf, "    pch <- true\n")
+			fmt.Fprintf(outf, "  }(pch)\n")
+		}
+		fmt.Fprintf(outf, "  for pidx := 0; pidx < %d; pidx++ {\n", numtpk)
+		fmt.Fprintf(outf, "    _ = <- pch\n")
+		fmt.Fprintf(outf, "  }\n")
 	}
-	fmt.Fprintf(outf, "  for pidx := 0; pidx < %d; pidx++ {\n", numtpk)
-	fmt.Fprintf(outf, "    _ = <- pch\n")
-	fmt.Fprintf(outf, "  }\n")
-	fmt.Fprintf(outf, "  tf := 0\n")
-	fmt.Fprintf(outf, "  for pidx := 0; pidx < %d; pidx++ {\n", numtpk)
-	fmt.Fprintf(outf, "    tf += %s.FailCount[pidx]\n", s.utilsPkg())
-	fmt.Fprintf(outf, "  }\n")
-	fmt.Fprintf(outf, "  if tf != 0 {\n")
+	if s.repeat > 1 {
+		s.emitRepeatHook(outf)
+		fmt.Fprintf(outf, "  }\n")
+	}
+	fmt.Fprintf(outf, "  if tf := %s.TotalFailCount(); tf != 0 {\n", s.utilsPkg())
 	fmt.Fprintf(outf, "    fmt.Fprintf(os.Stderr, \"FAILURES: %%d\\n\", tf)\n")
 	fmt.Fprintf(outf, "    os.Exit(2)\n")
 	fmt.Fprintf(outf, "  }\n")
-	fmt.Fprintf(outf, "  fmt.Fprintf(os.Stderr, \"finished %d tests\\n\")\n", numit*s.numtpk)
+	iters := 1
+	if s.repeat > 1 {
+		iters = s.repeat
+	}
+	fmt.Fprintf(outf, "  fmt.Fprintf(os.Stderr, \"finished %d tests\\n\")\n", numit*s.numtpk*iters)
 	fmt.Fprintf(outf, "}\n")
 }
 
-func makeDir(d string) {
-	verb(1, "creating %s", d)
-	os.Mkdir(d, 0777)
+// emitRepeatHook emits the code run by main between iterations of
+// the top level test loop.
+func (s *genstate) emitRepeatHook(outf io.Writer) {
+	switch s.rhook {
+	case "gc":
+		fmt.Fprintf(outf, "  runtime.GC()\n")
+	case "freeosmem":
+		fmt.Fprintf(outf, "  debug.FreeOSMemory()\n")
+	case "gosched":
+		fmt.Fprintf(outf, "  runtime.Gosched()\n")
+	case "cycle":
+		fmt.Fprintf(outf, "  switch iter %% 3 {\n")
+		fmt.Fprintf(outf, "  case 0:\n")
+		fmt.Fprintf(outf, "    runtime.GC()\n")
+		fmt.Fprintf(outf, "  case 1:\n")
+		fmt.Fprintf(outf, "    debug.FreeOSMemory()\n")
+		fmt.Fprintf(outf, "  default:\n")
+		fmt.Fprintf(outf, "    runtime.Gosched()\n")
+		fmt.Fprintf(outf, "  }\n")
+	default:
+		log.Fatalf("unknown repeat hook %q", s.rhook)
+	}
 }
 
 func (s *genstate) callerPkg(which int) string {
@@ -1872,7 +3194,7 @@ func (s *genstate) callerPkg(which int) string {
 
 func (s *genstate) callerFile(which int) string {
 	cp := s.callerPkg(which)
-	return s.outdir + "/" + cp + "/" + cp + ".go"
+	return cp + "/" + cp + ".go"
 }
 
 func (s *genstate) checkerPkg(which int) string {
@@ -1881,14 +3203,51 @@ func (s *genstate) checkerPkg(which int) string {
 
 func (s *genstate) checkerFile(which int) string {
 	cp := s.checkerPkg(which)
-	return s.outdir + "/" + cp + "/" + cp + ".go"
+	return cp + "/" + cp + ".go"
+}
+
+// ctxInParams returns true if each TestN receives the failure
+// reporting context of its caller through a hidden leading param.
+// This isn't the case for signatures taken from a spec, which are
+// tested exactly as written: CallerN instead stores the context in
+// the checker package variable CtxN (see ctxVar) before calling
+// TestN. This is safe since a given CallerN is never active on more
+// than one goroutine at a time.
+func (s *genstate) ctxInParams() bool {
+	return s.specs == nil
+}
+
+// ctxParam returns the declaration of the hidden leading param of
+// TestN, or "" if there is none.
+func (s *genstate) ctxParam() string {
+	if !s.ctxInParams() {
+		return ""
+	}
+	return fmt.Sprintf("ctx *%s.TestCtx", s.utilsPkg())
+}
+
+// ctxVar returns the name of the checker package variable holding
+// the context of test function 'f', when it has no ctx param.
+func (s *genstate) ctxVar(f *funcdef) string {
+	return fmt.Sprintf("Ctx%d", f.idx)
+}
+
+// writeParamCom writes the separator preceding param 'pi' in the
+// param list of a TestN declaration or call, which follows the
+// hidden ctx param, if any.
+func (s *genstate) writeParamCom(b *bytes.Buffer, pi int) {
+	if pi != 0 || s.ctxInParams() {
+		b.WriteString(", ")
+	}
 }
 
 func (s *genstate) utilsPkg() string {
 	return s.tag + "Utils"
 }
 
-func runImports(files []string) {
+// runImports runs goimports on the files in 'files', rewriting them
+// in place.
+func runImports(files []string) error {
 	verb(1, "... running goimports")
 	args := make([]string, 0, len(files)+1)
 	args = append(args, "-w")
@@ -1896,9 +3255,10 @@ func runImports(files []string) {
 	cmd := exec.Command("goimports", args...)
 	coutput, cerr := cmd.CombinedOutput()
 	if cerr != nil {
-		log.Fatalf("goimports command failed: %s", string(coutput))
+		return fmt.Errorf("goimports command failed: %v: %s", cerr, string(coutput))
 	}
 	verb(1, "... goimports run complete")
+	return nil
 }
 
 func emitFP(fn int, pk int, fcnmask map[int]int, pkmask map[int]int) bool {
@@ -1921,110 +3281,320 @@ func emitFP(fn int, pk int, fcnmask map[int]int, pkmask map[int]int) bool {
 	return doemit
 }
 
+// GenConfig contains configuration parameters relating to the
+// mechanics of code generation (number of packages and functions,
+// where output goes, how the main routine works, and so on), as
+// opposed to the shape of the test functions themselves, which is
+// controlled by TunableParams.
+type GenConfig struct {
+	// Tag is a string prefix prepended to the names of generated
+	// files and packages.
+	Tag string
+
+	// OutDir is the directory into which generated code is written.
+	OutDir string
+
+	// PkgPath is the import path of the generated module.
+	PkgPath string
+
+	// Number of test functions per package, and number of packages.
+	NumTestFunctions int
+	NumTestPackages  int
+
+	// Base seed for the random number generator. Each test function
+	// is generated from a seed derived from this one and from its
+	// position (package and function index).
+	Seed int64
+
+	// If non-empty, test functions are tagged with "//go:<Pragma>".
+	Pragma string
+
+	// Masks selecting subsets of functions/packages to emit (used
+	// for minimization); nil means emit everything.
+	FcnMask map[int]int
+	PkgMask map[int]int
+
+	// Emit inline utils code (for minimization).
+	UtilsInline bool
+
+	// Maximum runtime failures before the generated program exits.
+	MaxFail int
+
+	// If true, use hooks to force stack growth in test functions.
+	ForceStackGrowth bool
+
+	// Control flags for wraprand (RandCtlChecks etc).
+	RandCtl int
+
+	// If true, run 'goimports' on the generated code (only
+	// supported when writing to a directory).
+	RunGoImports bool
+
+	// If nonzero, the generated main runs each CallerN in its own
+	// goroutine, with at most TestParallelism of them active at
+	// once. If zero, main uses one goroutine per test package.
+	TestParallelism int
+
+	// If greater than one, the generated main runs the full set of
+	// tests Repeat times, invoking RepeatHook between iterations.
+	// RepeatHook is one of "gc" (runtime.GC), "freeosmem"
+	// (debug.FreeOSMemory), "gosched" (runtime.Gosched) or "cycle"
+	// (rotate through all of the above).
+	Repeat     int
+	RepeatHook string
+
+	// Destination for the generated files. If nil, files are
+	// written to the directory OutDir.
+	Output Output
+
+	// If non-nil, filled in with statistics about the generated
+	// code (see GenStats).
+	Stats *GenStats
+
+	// If true, type-check the generated packages in-process with
+	// go/types once they've been emitted, reporting any errors
+	// against the test function (and seed) that produced them.
+	TypeCheck bool
+
+	// If non-nil, test functions are generated with the signatures
+	// in Specs (one function per signature, in each package) rather
+	// than random ones; NumTestFunctions is ignored.
+	Specs *Specs
+
+	// Number of test packages to generate concurrently (values
+	// less than 2 mean generate them one at a time). The output is
+	// the same regardless of this setting.
+	Parallelism int
+}
+
+// RepeatHooks lists the legal values for GenConfig.RepeatHook.
+var RepeatHooks = []string{"gc", "freeosmem", "gosched", "cycle"}
+
+// genPackage generates the caller and checker code for test
+// package 'k', returning the names of the files written.
+func (s *genstate) genPackage(c GenConfig, k int, ipref string) []string {
+	callerImports := []string{s.checkerPkg(k), s.utilsPkg()}
+	checkerImports := []string{s.utilsPkg()}
+	if tunables.doReflectCall {
+		callerImports = append(callerImports, "reflect")
+	}
+	if tunables.gcInjectFraction != 0 {
+		checkerImports = append(checkerImports, "runtime")
+	}
+	if tunables.doGo {
+		checkerImports = append(checkerImports, "sync")
+	}
+	if s.sforce {
+		callerImports = append(callerImports, "unsafe")
+		checkerImports = append(checkerImports, "unsafe")
+	}
+
+	s.pkidx = k
+	s.errs = 0
+	s.stats = GenStats{}
+	s.files = nil
+	s.funcs = nil
+	s.newDerefFuncs = nil
+	s.newAssignFuncs = nil
+	s.newGlobVars = nil
+	s.derefFuncs = make(map[string]string)
+	s.assignFuncs = make(map[string]string)
+	s.allocFuncs = make(map[string]string)
+	s.globVars = make(map[string]string)
+	s.genvalFuncs = make(map[string]string)
+
+	// Packages are independent of one another, so there's no need
+	// to generate the ones that are masked out.
+	if !emitFP(-1, k, nil, c.PkgMask) {
+		return nil
+	}
+	calleroutfile := s.openOutputFile(s.callerFile(k), s.callerPkg(k),
+		callerImports, ipref)
+	checkeroutfile := s.openOutputFile(s.checkerFile(k), s.checkerPkg(k),
+		checkerImports, ipref)
+
+	// Each function gets its own seed (see funcSeed), so that it
+	// comes out the same no matter how many functions and packages
+	// are generated.
+	var b bytes.Buffer
+	for i := 0; i < c.NumTestFunctions; i++ {
+		if s.specs != nil {
+			s.spec = s.specs.funcs[i]
+		}
+		doemit := emitFP(i, k, c.FcnMask, c.PkgMask)
+		s.GenPair(calleroutfile, checkeroutfile, i, k,
+			&b, funcSeed(c.Seed, k, i), doemit)
+	}
+
+	// When minimization is in effect, we sometimes wind up eliminating
+	// all refs to the utils package. Add a dummy to help with this.
+	fmt.Fprintf(calleroutfile, "\n// dummy\nvar Dummy %s.UtilsType\n", s.utilsPkg())
+	fmt.Fprintf(checkeroutfile, "\n// dummy\nvar Dummy %s.UtilsType\n", s.utilsPkg())
+	if tunables.gcInjectFraction != 0 {
+		fmt.Fprintf(checkeroutfile, "var _ = runtime.GC\n")
+	}
+	if tunables.doGo {
+		fmt.Fprintf(checkeroutfile, "var _ sync.WaitGroup\n")
+	}
+	s.closeOutputFile(calleroutfile)
+	s.closeOutputFile(checkeroutfile)
+	return []string{s.callerFile(k), s.checkerFile(k)}
+}
+
+// Generate emits a test program with the specified settings, as
+// described in GenConfig, returning the number of errors encountered.
+// It is the original positional form of GenerateFromConfig, kept so
+// that existing callers continue to work; new code should use
+// GenerateFromConfig, which also provides access to the settings
+// added since.
 func Generate(tag string, outdir string, pkgpath string, numit int, numtpkgs int, seed int64, pragma string, fcnmask map[int]int, pkmask map[int]int, utilsinl bool, maxfail int, forcestackgrowth bool, randctl int, goimpflag bool) int {
-	mainpkg := tag + "Main"
+	return GenerateFromConfig(GenConfig{
+		Tag:              tag,
+		OutDir:           outdir,
+		PkgPath:          pkgpath,
+		NumTestFunctions: numit,
+		NumTestPackages:  numtpkgs,
+		Seed:             seed,
+		Pragma:           pragma,
+		FcnMask:          fcnmask,
+		PkgMask:          pkmask,
+		UtilsInline:      utilsinl,
+		MaxFail:          maxfail,
+		ForceStackGrowth: forcestackgrowth,
+		RandCtl:          randctl,
+		RunGoImports:     goimpflag,
+	})
+}
+
+// GenerateFromConfig emits a test program as described by 'c',
+// returning the number of errors encountered.
+func GenerateFromConfig(c GenConfig) int {
+	mainpkg := c.Tag + "Main"
+	if c.Specs != nil {
+		c.NumTestFunctions = len(c.Specs.funcs)
+	}
 
 	var ipref string
-	if len(pkgpath) > 0 {
-		ipref = pkgpath + "/"
+	if len(c.PkgPath) > 0 {
+		ipref = c.PkgPath + "/"
 	}
 
+	out := c.Output
+	if out == nil {
+		out = NewDirOutput(c.OutDir)
+	}
 	s := genstate{
-		outdir:  outdir,
+		out:     out,
 		ipref:   ipref,
-		tag:     tag,
-		numtpk:  numtpkgs,
-		pragma:  pragma,
-		sforce:  forcestackgrowth,
-		randctl: randctl,
-	}
-
-	if outdir != "." {
-		makeDir(outdir)
+		tag:     c.Tag,
+		numtpk:  c.NumTestPackages,
+		pragma:  c.Pragma,
+		sforce:  c.ForceStackGrowth,
+		randctl: c.RandCtl,
+		testpar: c.TestParallelism,
+		repeat:  c.Repeat,
+		rhook:   c.RepeatHook,
+
+		typecheck: c.TypeCheck,
+		specs:     c.Specs,
 	}
-	verb(1, "creating %s", outdir)
+	checkTunables(tunables)
+	s.tunables = tunables
 
 	mainimports := []string{}
-	for i := 0; i < numtpkgs; i++ {
-		if emitFP(-1, i, nil, pkmask) {
-			makeDir(outdir + "/" + s.callerPkg(i))
-			makeDir(outdir + "/" + s.checkerPkg(i))
-			makeDir(outdir + "/" + s.utilsPkg())
+	for i := 0; i < c.NumTestPackages; i++ {
+		if emitFP(-1, i, nil, c.PkgMask) {
 			mainimports = append(mainimports, s.callerPkg(i))
 		}
 	}
 	mainimports = append(mainimports, s.utilsPkg())
 
-	utilsfile := outdir + "/" + s.utilsPkg() + "/" + s.utilsPkg() + ".go"
+	utilsfile := s.utilsPkg() + "/" + s.utilsPkg() + ".go"
 	utilsoutfile := s.openOutputFile(utilsfile, s.utilsPkg(), []string{}, "")
 	verb(1, "emit utils")
-	emitUtils(utilsoutfile, maxfail, numtpkgs)
-	utilsoutfile.Close()
+	emitUtils(utilsoutfile, c.MaxFail, c.NumTestPackages)
+	s.closeOutputFile(utilsoutfile)
 
-	mainfile := outdir + "/" + mainpkg + ".go"
+	mainfile := mainpkg + ".go"
 	mainoutfile := s.openOutputFile(mainfile, "main", mainimports, ipref)
 
+	// Generate the test packages, each with its own copy of the
+	// generator state, possibly in parallel.
+	pkgstates := make([]genstate, c.NumTestPackages)
+	pkgfiles := make([][]string, c.NumTestPackages)
+	par := c.Parallelism
+	if par < 1 {
+		par = 1
+	}
+	sema := make(chan bool, par)
+	var wg sync.WaitGroup
+	for k := 0; k < c.NumTestPackages; k++ {
+		pkgstates[k] = s
+		wg.Add(1)
+		sema <- true
+		go func(k int) {
+			defer func() {
+				<-sema
+				wg.Done()
+			}()
+			pkgfiles[k] = pkgstates[k].genPackage(c, k, ipref)
+		}(k)
+	}
+	wg.Wait()
 	allfiles := []string{mainfile, utilsfile}
-	for k := 0; k < numtpkgs; k++ {
-		callerImports := []string{s.checkerPkg(k), s.utilsPkg()}
-		checkerImports := []string{s.utilsPkg()}
-		if tunables.doReflectCall {
-			callerImports = append(callerImports, "reflect")
-		}
-		if s.sforce {
-			callerImports = append(callerImports, "unsafe")
-			checkerImports = append(checkerImports, "unsafe")
-		}
-		var calleroutfile, checkeroutfile *os.File
-		if emitFP(-1, k, nil, pkmask) {
-			calleroutfile = s.openOutputFile(s.callerFile(k), s.callerPkg(k),
-				callerImports, ipref)
-			checkeroutfile = s.openOutputFile(s.checkerFile(k), s.checkerPkg(k),
-				checkerImports, ipref)
-			allfiles = append(allfiles, s.callerFile(k), s.checkerFile(k))
-		}
-
-		s.pkidx = k
-		s.newDerefFuncs = nil
-		s.newAssignFuncs = nil
-		s.newGlobVars = nil
-		s.derefFuncs = make(map[string]string)
-		s.assignFuncs = make(map[string]string)
-		s.allocFuncs = make(map[string]string)
-		s.globVars = make(map[string]string)
-		s.genvalFuncs = make(map[string]string)
-
-		var b bytes.Buffer
-		for i := 0; i < numit; i++ {
-			doemit := emitFP(i, k, fcnmask, pkmask)
-			seed = s.GenPair(calleroutfile, checkeroutfile, i, k,
-				&b, seed, doemit)
-		}
-
-		// When minimization is in effect, we sometimes wind up eliminating
-		// all refs to the utils package. Add a dummy to help with this.
-		fmt.Fprintf(calleroutfile, "\n// dummy\nvar Dummy %s.UtilsType\n", s.utilsPkg())
-		fmt.Fprintf(checkeroutfile, "\n// dummy\nvar Dummy %s.UtilsType\n", s.utilsPkg())
-		calleroutfile.Close()
-		checkeroutfile.Close()
-	}
-	s.emitMain(mainoutfile, numit, fcnmask, pkmask, numtpkgs)
+	manifest := newManifest(c, tunables)
+	for k := range pkgstates {
+		allfiles = append(allfiles, pkgfiles[k]...)
+		s.errs += pkgstates[k].errs
+		s.stats.add(pkgstates[k].stats)
+		s.files = append(s.files, pkgstates[k].files...)
+		manifest.Funcs = append(manifest.Funcs, pkgstates[k].funcs...)
+	}
+	s.emitMain(mainoutfile, c.NumTestFunctions, c.FcnMask, c.PkgMask, c.NumTestPackages)
 
 	// emit go.mod
-	verb(1, "opening go.mod")
-	fn := outdir + "/go.mod"
-	outf, err := os.OpenFile(fn, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	outf, err := s.out.Create("go.mod")
 	if err != nil {
 		log.Fatal(err)
 	}
-	outf.WriteString(fmt.Sprintf("module %s\n\ngo 1.15\n", pkgpath))
+	fmt.Fprintf(outf, "module %s\n\ngo %s\n", c.PkgPath, genGoVersion)
 	outf.Close()
 
+	if err := s.writeManifest(manifest); err != nil {
+		log.Fatal(err)
+	}
+
 	verb(1, "closing files")
-	mainoutfile.Close()
+	s.closeOutputFile(mainoutfile)
+
+	if s.errs == 0 && c.TypeCheck {
+		verb(1, "type-checking generated code")
+		mainpath := c.PkgPath
+		if mainpath == "" {
+			mainpath = "main"
+		}
+		for _, msg := range typeCheck(s.files, ipref, mainpath) {
+			fmt.Fprintf(os.Stderr, "internal error: %s\n", msg)
+			s.errs++
+		}
+	}
+
+	if s.errs == 0 && c.RunGoImports {
+		d, ok := s.out.(*DirOutput)
+		if !ok {
+			log.Fatal("goimports can only be run on directory output")
+		}
+		for i := range allfiles {
+			allfiles[i] = d.Path(allfiles[i])
+		}
+		if err := runImports(allfiles); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			s.errs++
+		}
+	}
 
-	if s.errs == 0 && goimpflag {
-		runImports(allfiles)
+	if c.Stats != nil {
+		*c.Stats = s.stats
 	}
 
 	return s.errs
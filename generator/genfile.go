@@ -0,0 +1,87 @@
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/scanner"
+	"os"
+)
+
+// genFile accumulates the contents of a generated source file, which
+// is formatted with go/format and written out once complete. Along
+// the way it records which test function produced each region of
+// the file, so that problems can be traced back to the function
+// index and seed that caused them.
+type genFile struct {
+	name    string
+	buf     bytes.Buffer
+	regions []funcRegion
+	src     []byte // final (formatted) contents
+}
+
+// funcRegion records that bytes [start,end) of a generated file
+// were emitted for test function 'fidx' from seed 'seed'.
+type funcRegion struct {
+	start, end int
+	fidx       int
+	seed       int64
+}
+
+func (gf *genFile) Write(p []byte) (int, error) {
+	return gf.buf.Write(p)
+}
+
+// writeFunc appends the code for test function 'fidx' (in 'b') to
+// the file, noting the region it occupies.
+func (gf *genFile) writeFunc(b *bytes.Buffer, fidx int, seed int64) {
+	start := gf.buf.Len()
+	b.WriteTo(&gf.buf)
+	gf.regions = append(gf.regions, funcRegion{start, gf.buf.Len(), fidx, seed})
+}
+
+// lineRegion returns the region containing line 'line' (1-based)
+// of the file, or nil if it isn't part of any test function.
+func (gf *genFile) lineRegion(line int) *funcRegion {
+	content := gf.buf.Bytes()
+	off := 0
+	for l := 1; l < line && off < len(content); l++ {
+		i := bytes.IndexByte(content[off:], '\n')
+		if i < 0 {
+			return nil
+		}
+		off += i + 1
+	}
+	for k := range gf.regions {
+		if r := &gf.regions[k]; off >= r.start && off < r.end {
+			return r
+		}
+	}
+	return nil
+}
+
+// format runs the file contents through go/format, returning the
+// formatted source. If the contents don't parse, it reports each
+// error (along with the test function responsible for it, where
+// possible) and returns the unformatted source along with a count
+// of the errors.
+func (gf *genFile) format() ([]byte, int) {
+	src, err := format.Source(gf.buf.Bytes())
+	if err == nil {
+		return src, 0
+	}
+	el, ok := err.(scanner.ErrorList)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "internal error: formatting %s: %v\n", gf.name, err)
+		return gf.buf.Bytes(), 1
+	}
+	for _, e := range el {
+		where := ""
+		if r := gf.lineRegion(e.Pos.Line); r != nil {
+			where = fmt.Sprintf(" (function %d, seed %d)", r.fidx, r.seed)
+		}
+		fmt.Fprintf(os.Stderr, "internal error: malformed output %s:%d:%d: %s%s\n",
+			gf.name, e.Pos.Line, e.Pos.Column, e.Msg, where)
+	}
+	return gf.buf.Bytes(), len(el)
+}
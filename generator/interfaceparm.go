@@ -0,0 +1,70 @@
+package generator
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// interfaceparm describes a parameter of empty interface type; it
+// implements the "parm" interface. Random signatures only use these
+// as map keys, but they can appear anywhere in user-supplied ones
+// (see Specs).
+type interfaceparm struct {
+	isBlank
+	addrTakenHow
+	isGenValFunc
+	skipCompare
+}
+
+// dynTypes is the set of dynamic types we store in interface values.
+// Note that several of these have the same representation, so that
+// equal-looking values of different types can be put side by side.
+var dynTypes = []parm{
+	&numparm{tag: "int", widthInBits: 32},
+	&numparm{tag: "int", widthInBits: 64},
+	&numparm{tag: "uint", widthInBits: 32},
+	&numparm{tag: "float", widthInBits: 64},
+	&stringparm{tag: "string"},
+}
+
+func (p interfaceparm) Declare(b *bytes.Buffer, prefix string, suffix string, caller bool) {
+	b.WriteString(prefix + " interface{}" + suffix)
+}
+
+func (p interfaceparm) GenElemRef(elidx int, path string) (string, parm) {
+	return path, &p
+}
+
+func (p interfaceparm) GenValue(s *genstate, f *funcdef, value int, caller bool) (string, int) {
+	dt := dynTypes[s.wr.Intn(len(dynTypes))]
+	var valstr string
+	valstr, value = dt.GenValue(s, f, value, caller)
+	return fmt.Sprintf("interface{}(%s)", valstr), value
+}
+
+func (p interfaceparm) IsControl() bool {
+	return false
+}
+
+func (p interfaceparm) NumElements() int {
+	return 1
+}
+
+func (p interfaceparm) String() string {
+	return "interface{}"
+}
+
+func (p interfaceparm) TypeName() string {
+	return "interface{}"
+}
+
+func (p interfaceparm) QualName() string {
+	return "interface{}"
+}
+
+// HasPointer reports false: all of the dynamic types we use are
+// comparable, so interface values can be compared with "==" rather
+// than an equality helper.
+func (p interfaceparm) HasPointer() bool {
+	return false
+}
@@ -0,0 +1,389 @@
+package generator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Version identifies the revision of the generator. It is recorded
+// in the manifest, and should be bumped whenever a change to the
+// generator alters the code emitted for a given seed;
+// TestVersionGolden fails if it isn't.
+const Version = "0.8"
+
+// Manifest records the settings used by Generate, along with the
+// seed and signature of each test function emitted, so that any one
+// of them can be regenerated later on (see RegenConfig). Generate
+// writes the manifest to "manifest.json" in the output.
+type Manifest struct {
+	Version          string         `json:"version"`
+	Tag              string         `json:"tag"`
+	PkgPath          string         `json:"pkgpath"`
+	NumTestFunctions int            `json:"numfuncs"`
+	NumTestPackages  int            `json:"numpkgs"`
+	Seed             int64          `json:"seed"`
+	FcnMask          []int          `json:"fcnmask,omitempty"`
+	PkgMask          []int          `json:"pkgmask,omitempty"`
+	Pragma           string         `json:"pragma,omitempty"`
+	UtilsInline      bool           `json:"utilsinline,omitempty"`
+	MaxFail          int            `json:"maxfail"`
+	ForceStackGrowth bool           `json:"forcestackgrowth"`
+	RandCtl          int            `json:"randctl"`
+	TestParallelism  int            `json:"testpar,omitempty"`
+	Repeat           int            `json:"repeat,omitempty"`
+	RepeatHook       string         `json:"repeathook,omitempty"`
+	Specs            string         `json:"specs,omitempty"`
+	Tunables         TunableParams  `json:"tunables"`
+	Funcs            []ManifestFunc `json:"funcs"`
+}
+
+// ManifestFunc describes a single test function.
+type ManifestFunc struct {
+	// Package and function index.
+	Pkg int `json:"pkg"`
+	Fn  int `json:"fn"`
+
+	// Checker package and function name, e.g. "genChecker0.Test3".
+	Name string `json:"name"`
+
+	// Seed from which the function was generated.
+	Seed int64 `json:"seed"`
+
+	// Receiver type (for methods) and function signature, with
+	// named types expanded.
+	Receiver  string `json:"receiver,omitempty"`
+	Signature string `json:"signature"`
+}
+
+// funcSeed returns the seed for test function 'fidx' of package
+// 'pidx'. It depends only on the base seed and the position of the
+// function, so that the function doesn't change when more (or fewer)
+// functions or packages are generated around it. The result is
+// always non-negative, so it can be handed back to the generator.
+func funcSeed(base int64, pidx, fidx int) int64 {
+	// splitmix64 finalizer
+	x := uint64(base) + 0x9e3779b97f4a7c15*(uint64(pidx)<<32|uint64(uint32(fidx))+1)
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	x ^= x >> 31
+	return int64(x >> 1)
+}
+
+// newManifest returns a manifest describing config 'c' and tunables
+// 't', with no functions.
+func newManifest(c GenConfig, t TunableParams) *Manifest {
+	m := &Manifest{
+		Version:          Version,
+		Tag:              c.Tag,
+		PkgPath:          c.PkgPath,
+		NumTestFunctions: c.NumTestFunctions,
+		NumTestPackages:  c.NumTestPackages,
+		Seed:             c.Seed,
+		FcnMask:          maskList(c.FcnMask),
+		PkgMask:          maskList(c.PkgMask),
+		Pragma:           c.Pragma,
+		UtilsInline:      c.UtilsInline,
+		MaxFail:          c.MaxFail,
+		ForceStackGrowth: c.ForceStackGrowth,
+		RandCtl:          c.RandCtl,
+		TestParallelism:  c.TestParallelism,
+		Repeat:           c.Repeat,
+		RepeatHook:       c.RepeatHook,
+		Tunables:         t,
+		Funcs:            []ManifestFunc{},
+	}
+	if c.Specs != nil {
+		m.Specs = c.Specs.src
+	}
+	return m
+}
+
+// maskList returns the indices selected by function or package mask
+// 'm', in increasing order.
+func maskList(m map[int]int) []int {
+	var l []int
+	for i := range m {
+		l = append(l, i)
+	}
+	sort.Ints(l)
+	return l
+}
+
+// maskMap is the inverse of maskList.
+func maskMap(l []int) map[int]int {
+	if len(l) == 0 {
+		return nil
+	}
+	m := make(map[int]int)
+	for _, i := range l {
+		m[i] = 1
+	}
+	return m
+}
+
+// manifestFunc returns the manifest entry for test function 'f' of
+// package 'pidx', generated from seed 'seed'.
+func (s *genstate) manifestFunc(f *funcdef, pidx int, seed int64) ManifestFunc {
+	mf := ManifestFunc{
+		Pkg:       pidx,
+		Fn:        f.idx,
+		Name:      fmt.Sprintf("%s.Test%d", s.checkerPkg(pidx), f.idx),
+		Seed:      seed,
+		Signature: f.signature(),
+	}
+	if f.method {
+		mf.Receiver = typeExpr(f.receiver)
+		if f.ptrrcvr {
+			mf.Receiver = "*" + mf.Receiver
+		}
+	}
+	return mf
+}
+
+// signature returns the type of 'f' as a Go func type expression,
+// e.g. "func(int8, struct{F0 string; _ float64}) (uint16, []byte)".
+func (f *funcdef) signature() string {
+	var b strings.Builder
+	b.WriteString("func(")
+	for i, p := range f.params {
+		if i != 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(typeExpr(p))
+	}
+	b.WriteString(")")
+	if len(f.returns) == 1 {
+		b.WriteString(" " + typeExpr(f.returns[0]))
+	} else if len(f.returns) > 1 {
+		b.WriteString(" (")
+		for i, r := range f.returns {
+			if i != 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(typeExpr(r))
+		}
+		b.WriteString(")")
+	}
+	return b.String()
+}
+
+// typeExpr returns a Go type expression for 'p', with any named
+// types (structs, arrays, typedefs) expanded.
+func typeExpr(p parm) string {
+	switch x := p.(type) {
+	case *numparm:
+		return x.TypeName()
+	case *stringparm:
+		return "string"
+	case *interfaceparm:
+		return "interface{}"
+	case *pointerparm:
+		return "*" + typeExpr(x.totype)
+	case *typedefparm:
+		return typeExpr(x.target)
+	case *mapparm:
+		return "map[" + typeExpr(x.keytype) + "]" + typeExpr(x.valtype)
+	case *arrayparm:
+		if x.slice {
+			return "[]" + typeExpr(x.eltype)
+		}
+		return fmt.Sprintf("[%d]%s", x.nelements, typeExpr(x.eltype))
+	case *structparm:
+		fl := make([]string, len(x.fields))
+		for i, fp := range x.fields {
+			fl[i] = x.FieldName(i) + " " + typeExpr(fp)
+		}
+		return "struct{" + strings.Join(fl, "; ") + "}"
+	}
+	panic(fmt.Sprintf("unexpected parm type %T", p))
+}
+
+// tunableField pairs the name of a tunable with a pointer to it.
+type tunableField struct {
+	name string
+	ptr  interface{}
+}
+
+// fields returns a table of the tunables in 't', which is used to
+// (de)serialize them.
+func (t *TunableParams) fields() []tunableField {
+	return []tunableField{
+		{"nParmRange", &t.nParmRange},
+		{"nReturnRange", &t.nReturnRange},
+		{"nStructFields", &t.nStructFields},
+		{"nArrayElements", &t.nArrayElements},
+		{"sliceFraction", &t.sliceFraction},
+		{"nMapEntries", &t.nMapEntries},
+		{"specialKeyFraction", &t.specialKeyFraction},
+		{"nilFraction", &t.nilFraction},
+		{"intBitRanges", &t.intBitRanges},
+		{"floatBitRanges", &t.floatBitRanges},
+		{"stringKindFractions", &t.stringKindFractions},
+		{"stringViaBytesFraction", &t.stringViaBytesFraction},
+		{"unsignedRanges", &t.unsignedRanges},
+		{"blankPerc", &t.blankPerc},
+		{"structDepth", &t.structDepth},
+		{"typeFractions", &t.typeFractions},
+		{"recurPerc", &t.recurPerc},
+		{"methodPerc", &t.methodPerc},
+		{"pointerMethodCallPerc", &t.pointerMethodCallPerc},
+		{"doReflectCall", &t.doReflectCall},
+		{"takeAddress", &t.takeAddress},
+		{"takenFraction", &t.takenFraction},
+		{"addrFractions", &t.addrFractions},
+		{"doDefer", &t.doDefer},
+		{"deferFraction", &t.deferFraction},
+		{"doGo", &t.doGo},
+		{"goFraction", &t.goFraction},
+		{"doPanic", &t.doPanic},
+		{"panicFraction", &t.panicFraction},
+		{"returnStyleFractions", &t.returnStyleFractions},
+		{"doMutate", &t.doMutate},
+		{"mutateFraction", &t.mutateFraction},
+		{"sliceAliasFraction", &t.sliceAliasFraction},
+		{"doFuncCallValues", &t.doFuncCallValues},
+		{"funcCallValFraction", &t.funcCallValFraction},
+		{"doSkipCompare", &t.doSkipCompare},
+		{"skipCompareFraction", &t.skipCompareFraction},
+		{"regBoundaryPerc", &t.regBoundaryPerc},
+		{"goarch", &t.goarch},
+		{"gcInjectFraction", &t.gcInjectFraction},
+		{"zeroSizePerc", &t.zeroSizePerc},
+	}
+}
+
+// MarshalJSON encodes the tunables as a JSON object, with one member
+// per tunable.
+func (t TunableParams) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("{")
+	for i, tf := range t.fields() {
+		v, err := json.Marshal(tf.ptr)
+		if err != nil {
+			return nil, err
+		}
+		if i != 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, "%q:%s", tf.name, v)
+	}
+	b.WriteString("}")
+	return b.Bytes(), nil
+}
+
+// UnmarshalJSON decodes tunables encoded by MarshalJSON. Tunables
+// missing from the encoding keep their current values.
+func (t *TunableParams) UnmarshalJSON(data []byte) error {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	for _, tf := range t.fields() {
+		if v, ok := m[tf.name]; ok {
+			if err := json.Unmarshal(v, tf.ptr); err != nil {
+				return fmt.Errorf("tunable %s: %v", tf.name, err)
+			}
+			delete(m, tf.name)
+		}
+	}
+	for n := range m {
+		return fmt.Errorf("unknown tunable %q", n)
+	}
+	return nil
+}
+
+// writeManifest writes 'm' to the file "manifest.json".
+func (s *genstate) writeManifest(m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	outf, err := s.out.Create("manifest.json")
+	if err != nil {
+		return err
+	}
+	if _, err := outf.Write(append(data, '\n')); err != nil {
+		outf.Close()
+		return err
+	}
+	return outf.Close()
+}
+
+// ReadManifest reads a manifest written by Generate.
+func ReadManifest(r io.Reader) (*Manifest, error) {
+	m := &Manifest{Tunables: DefaultTunables()}
+	if err := json.NewDecoder(r).Decode(m); err != nil {
+		return nil, fmt.Errorf("reading manifest: %v", err)
+	}
+	return m, nil
+}
+
+// Config returns the configuration and tunables that regenerate
+// the whole of the output described by the manifest. As with
+// RegenConfig, the caller fills in the destination of the output and
+// installs the tunables.
+func (m *Manifest) Config() (GenConfig, TunableParams, error) {
+	if m.Version != Version {
+		return GenConfig{}, TunableParams{}, fmt.Errorf("manifest is from generator version %s, this is version %s", m.Version, Version)
+	}
+	c := GenConfig{
+		Tag:              m.Tag,
+		PkgPath:          m.PkgPath,
+		NumTestFunctions: m.NumTestFunctions,
+		NumTestPackages:  m.NumTestPackages,
+		Seed:             m.Seed,
+		Pragma:           m.Pragma,
+		FcnMask:          maskMap(m.FcnMask),
+		PkgMask:          maskMap(m.PkgMask),
+		UtilsInline:      m.UtilsInline,
+		MaxFail:          m.MaxFail,
+		ForceStackGrowth: m.ForceStackGrowth,
+		RandCtl:          m.RandCtl,
+		TestParallelism:  m.TestParallelism,
+		Repeat:           m.Repeat,
+		RepeatHook:       m.RepeatHook,
+	}
+	if m.Specs != "" {
+		specs, err := ParseSpecs("manifest", []byte(m.Specs))
+		if err != nil {
+			return GenConfig{}, TunableParams{}, err
+		}
+		c.Specs = specs
+	}
+	return c, m.Tunables, nil
+}
+
+// RegenConfig returns the configuration and tunables that
+// regenerate test function 'fn' of package 'pkg' from the manifest,
+// on its own, using the same masking machinery as minimization. The
+// function comes out as it did originally, apart from the names of
+// any helper functions it calls. The caller still needs to fill in
+// the destination of the output, and to install the tunables with
+// SetTunables before calling GenerateFromConfig.
+func (m *Manifest) RegenConfig(pkg, fn int) (GenConfig, TunableParams, error) {
+	c, t, err := m.Config()
+	if err != nil {
+		return GenConfig{}, TunableParams{}, err
+	}
+	var mf *ManifestFunc
+	for i := range m.Funcs {
+		if m.Funcs[i].Pkg == pkg && m.Funcs[i].Fn == fn {
+			mf = &m.Funcs[i]
+		}
+	}
+	if mf == nil {
+		return GenConfig{}, TunableParams{}, fmt.Errorf("no function %d:%d in manifest", pkg, fn)
+	}
+	if seed := funcSeed(m.Seed, pkg, fn); seed != mf.Seed {
+		return GenConfig{}, TunableParams{}, fmt.Errorf("function %d:%d: manifest seed %d doesn't match base seed (want %d)", pkg, fn, mf.Seed, seed)
+	}
+	c.NumTestFunctions = fn + 1
+	c.NumTestPackages = pkg + 1
+	c.FcnMask = map[int]int{fn: 1}
+	c.PkgMask = map[int]int{pkg: 1}
+	return c, t, nil
+}
@@ -0,0 +1,132 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Special map key flavors. Each of these produces a map whose first
+// two keys are distinct Go values that nonetheless compare equal, so
+// that the second entry overwrites the first, plus (optionally) more
+// keys that look similar to the first two but must not collide.
+const (
+	// struct containing a float, with keys holding +0.0 and -0.0
+	specialKeyFloatStruct = iota
+	// array, with the second key a copy of the first
+	specialKeyArray
+	// string keys with a long shared prefix, with the second key
+	// rebuilt from the bytes of the first
+	specialKeyString
+	// interface{} keys holding the same value with different
+	// dynamic types
+	specialKeyInterface
+	numSpecialKeys
+)
+
+// genSpecialKeyType returns a map key type for the specified
+// special key flavor.
+func (s *genstate) genSpecialKeyType(f *funcdef, kind int, pidx int) parm {
+	switch kind {
+	case specialKeyFloatStruct:
+		var sp structparm
+		ns := len(f.structdefs)
+		sp.sname = fmt.Sprintf("StructF%dS%d", f.idx, ns)
+		sp.qname = fmt.Sprintf("%s.StructF%dS%d", s.checkerPkg(pidx), f.idx, ns)
+		fp := &numparm{tag: "float", widthInBits: s.floatBits()}
+		ip := &numparm{tag: s.intFlavor(), widthInBits: 32}
+		sp.fields = []parm{fp, ip}
+		f.structdefs = append(f.structdefs, sp)
+		return &sp
+	case specialKeyArray:
+		var ap arrayparm
+		ns := len(f.arraydefs)
+		nel := 1 + s.wr.Intn(3)
+		ap.aname = fmt.Sprintf("ArrayF%dS%dE%d", f.idx, ns, nel)
+		ap.qname = fmt.Sprintf("%s.%s", s.checkerPkg(pidx), ap.aname)
+		ap.nelements = uint8(nel)
+		ap.eltype = &numparm{tag: s.intFlavor(), widthInBits: 16}
+		f.arraydefs = append(f.arraydefs, ap)
+		return &ap
+	case specialKeyString:
+		return &stringparm{tag: "string"}
+	case specialKeyInterface:
+		return &interfaceparm{}
+	}
+	panic("bad special key kind")
+}
+
+// specialKeyFixups returns, for each of the key temps in 'keytmps'
+// (all of the special key flavor 'kind'), statements that overwrite
+// parts of the randomly generated key value so as to set up the
+// desired collisions. The first key is always shadowed by the second.
+func (s *genstate) specialKeyFixups(kind int, keytmps []string) [][]string {
+	fixes := make([][]string, len(keytmps))
+	k0 := keytmps[0]
+	switch kind {
+	case specialKeyFloatStruct:
+		for e, kt := range keytmps {
+			d := e
+			if e == 1 {
+				d = 0
+			}
+			fixes[e] = []string{fmt.Sprintf("%s.F0 = 0", kt), fmt.Sprintf("%s.F1 = %d", kt, d)}
+		}
+		// Negate at runtime, since the constant -0.0 is just 0.
+		fixes[1] = append(fixes[1], fmt.Sprintf("%s.F0 = -%s.F0 // -0.0", keytmps[1], keytmps[1]))
+	case specialKeyArray:
+		for e, kt := range keytmps {
+			fixes[e] = []string{fmt.Sprintf("%s[0] = %d", kt, e)}
+		}
+		fixes[1] = []string{fmt.Sprintf("%s = %s", keytmps[1], k0)}
+	case specialKeyString:
+		var sb strings.Builder
+		n := 16 + s.wr.Intn(48)
+		for i := 0; i < n; i++ {
+			sb.WriteByte(byte('a' + i%26))
+		}
+		pfx := sb.String()
+		for e, kt := range keytmps {
+			fixes[e] = []string{fmt.Sprintf("%s = \"%s%d\" + %s", kt, pfx, e, kt)}
+		}
+		fixes[1] = []string{fmt.Sprintf("%s = string([]byte(%s))", keytmps[1], k0)}
+	case specialKeyInterface:
+		v := s.wr.Intn(100)
+		// Keys 0 and 1 have the same dynamic type, chosen at
+		// random; the other keys take the remaining types in
+		// random order, with the value bumped each time the
+		// types run out.
+		perm := make([]parm, len(dynTypes))
+		copy(perm, dynTypes)
+		for i := len(perm) - 1; i > 0; i-- {
+			j := s.wr.Intn(i + 1)
+			perm[i], perm[j] = perm[j], perm[i]
+		}
+		for e, kt := range keytmps {
+			t, ev := perm[0], v
+			if e > 1 {
+				t = perm[1+(e-2)%(len(perm)-1)]
+				ev += (e - 2) / (len(perm) - 1)
+			}
+			lit := fmt.Sprintf("%s(%d)", t.TypeName(), ev)
+			if isStringParm(t) {
+				lit = fmt.Sprintf("\"%d\"", ev)
+			}
+			fixes[e] = []string{fmt.Sprintf("%s = %s", kt, lit)}
+		}
+	default:
+		panic("bad special key kind")
+	}
+	return fixes
+}
+
+// discFixup returns a statement that sets the discriminator leaf of
+// key temp 'kt' (of type 't') to 'd', so as to make it distinct from
+// the other keys in the same map.
+func discFixup(t parm, kt string, d int) string {
+	elref, elparm := t.GenElemRef(keyLeaf(t), kt)
+	c := fmt.Sprintf("%d", d)
+	if isStringParm(elparm) {
+		c = fmt.Sprintf("\"%d\"", d)
+	}
+	return fmt.Sprintf("%s = %s // discriminator", elref, c)
+}
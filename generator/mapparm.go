@@ -12,7 +12,13 @@ type mapparm struct {
 	qname   string
 	keytype parm
 	valtype parm
-	keytmp  string
+	// key temps for the entries in the map, one per entry
+	keytmps []string
+	// for maps with no entries, true if the map is nil
+	isnil bool
+	// shadowed[i] is true if the key for entry i compares equal to
+	// the key of a later entry, which overwrites it
+	shadowed []bool
 	isBlank
 	addrTakenHow
 	isGenValFunc
@@ -53,12 +59,17 @@ func (p mapparm) GenValue(s *genstate, f *funcdef, value int, caller bool) (stri
 	if caller {
 		n = p.qname
 	}
+	if p.isnil {
+		return fmt.Sprintf("%s(nil)", n), value
+	}
 	buf.WriteString(fmt.Sprintf("%s{", n))
-	buf.WriteString(p.keytmp + ": ")
-
-	var valstr string
-	valstr, value = s.GenValue(f, p.valtype, value, caller)
-	buf.WriteString(valstr + "}")
+	for i, kt := range p.keytmps {
+		var valstr string
+		valstr, value = s.GenValue(f, p.valtype, value, caller)
+		writeCom(&buf, i)
+		buf.WriteString(kt + ": " + valstr)
+	}
+	buf.WriteString("}")
 	return buf.String(), value
 }
 
@@ -66,20 +77,41 @@ func (p mapparm) GenElemRef(elidx int, path string) (string, parm) {
 	vne := p.valtype.NumElements()
 	verb(4, "begin GenElemRef(%d,%s) on %s %d", elidx, path, p.String(), vne)
 
-	ppath := fmt.Sprintf("%s[mkt.%s]", path, p.keytmp)
+	// Find the entry containing the element of interest
+	slot := elidx / vne
+	ppath := fmt.Sprintf("%s[mkt.%s]", path, p.keytmps[slot])
+
+	// Elements of shadowed entries can't be referenced; we still
+	// need the element type so that values can be generated.
+	if p.shadowed != nil && p.shadowed[slot] {
+		_, ep := p.valtype.GenElemRef(elidx-(slot*vne), "x")
+		return "_", ep
+	}
 
 	// otherwise dig into the value
 	verb(4, "recur GenElemRef(%d,...)", elidx)
 
 	// Otherwise our victim is somewhere inside the value
-	if p.IsBlank() {
+	if path == "_" || p.IsBlank() {
 		ppath = "_"
 	}
-	return p.valtype.GenElemRef(elidx, ppath)
+	return p.valtype.GenElemRef(elidx-(slot*vne), ppath)
 }
 
 func (p mapparm) NumElements() int {
-	return p.valtype.NumElements()
+	return p.valtype.NumElements() * len(p.keytmps)
+}
+
+// numDistinct returns the number of entries in the map once any
+// shadowed entries are overwritten.
+func (p mapparm) numDistinct() int {
+	n := 0
+	for i := range p.keytmps {
+		if p.shadowed == nil || !p.shadowed[i] {
+			n++
+		}
+	}
+	return n
 }
 
 func (p mapparm) HasPointer() bool {
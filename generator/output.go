@@ -0,0 +1,287 @@
+package generator
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Output is the destination for the files created by Generate. File
+// names are slash-separated paths relative to the root of the
+// generated module, e.g. "genCaller0/genCaller0.go" or "go.mod".
+// When packages are generated in parallel, Create may be called
+// from several goroutines at once.
+type Output interface {
+	// Create returns a writer for the file 'name'. The file is
+	// complete once the writer has been closed.
+	Create(name string) (io.WriteCloser, error)
+}
+
+// DirOutput writes files into a directory on disk, creating any
+// subdirectories as needed.
+type DirOutput struct {
+	Dir string
+}
+
+func NewDirOutput(dir string) *DirOutput {
+	return &DirOutput{Dir: dir}
+}
+
+func (d *DirOutput) Create(name string) (io.WriteCloser, error) {
+	path := d.Path(name)
+	verb(1, "opening %s", path)
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// Path returns the on-disk path of the file 'name'.
+func (d *DirOutput) Path(name string) string {
+	return filepath.Join(d.Dir, filepath.FromSlash(name))
+}
+
+// MemOutput collects files in memory.
+type MemOutput struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func NewMemOutput() *MemOutput {
+	return &MemOutput{files: make(map[string][]byte)}
+}
+
+func (m *MemOutput) Create(name string) (io.WriteCloser, error) {
+	return &memFile{m: m, name: name}, nil
+}
+
+// Files returns a copy of the files written so far, keyed by name.
+func (m *MemOutput) Files() map[string][]byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	files := make(map[string][]byte, len(m.files))
+	for n, c := range m.files {
+		files[n] = c
+	}
+	return files
+}
+
+// Names returns the sorted names of the files written so far.
+func (m *MemOutput) Names() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.files))
+	for n := range m.files {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FS returns a read-only file system holding the files written so
+// far, laid out as they would be under the output directory (e.g.
+// for handing to go/build or fs.WalkDir). It is a snapshot; files
+// written afterwards don't show up in it.
+func (m *MemOutput) FS() fs.FS {
+	mfs := &memFS{files: m.Files(), dirs: map[string][]string{".": nil}}
+	seen := make(map[string]bool)
+	for n := range mfs.files {
+		for n != "." && !seen[n] {
+			seen[n] = true
+			dir := path.Dir(n)
+			mfs.dirs[dir] = append(mfs.dirs[dir], path.Base(n))
+			n = dir
+		}
+	}
+	for _, ents := range mfs.dirs {
+		sort.Strings(ents)
+	}
+	return mfs
+}
+
+// memFS is the file system returned by MemOutput.FS. 'dirs' maps
+// each directory (including the root, ".") to the sorted names of
+// its entries.
+type memFS struct {
+	files map[string][]byte
+	dirs  map[string][]string
+}
+
+func (mfs *memFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if c, ok := mfs.files[name]; ok {
+		return &memFSFile{Reader: bytes.NewReader(c), info: mfs.stat(name)}, nil
+	}
+	if _, ok := mfs.dirs[name]; ok {
+		return &memFSDir{mfs: mfs, name: name, info: mfs.stat(name)}, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// stat returns the file info for the existing file or directory
+// 'name'.
+func (mfs *memFS) stat(name string) memFileInfo {
+	c, ok := mfs.files[name]
+	if !ok {
+		return memFileInfo{name: path.Base(name), mode: fs.ModeDir | 0555}
+	}
+	return memFileInfo{name: path.Base(name), size: int64(len(c)), mode: 0444}
+}
+
+// memFSFile is an open regular file in a memFS.
+type memFSFile struct {
+	*bytes.Reader
+	info memFileInfo
+}
+
+func (f *memFSFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memFSFile) Close() error               { return nil }
+
+// memFSDir is an open directory in a memFS; 'off' is the number of
+// entries already returned by ReadDir.
+type memFSDir struct {
+	mfs  *memFS
+	name string
+	info memFileInfo
+	off  int
+}
+
+func (d *memFSDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *memFSDir) Close() error               { return nil }
+
+func (d *memFSDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *memFSDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	names := d.mfs.dirs[d.name][d.off:]
+	if n > 0 && len(names) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && n < len(names) {
+		names = names[:n]
+	}
+	ents := make([]fs.DirEntry, 0, len(names))
+	for _, n := range names {
+		ents = append(ents, fs.FileInfoToDirEntry(d.mfs.stat(path.Join(d.name, n))))
+	}
+	d.off += len(names)
+	return ents, nil
+}
+
+// memFileInfo describes a file or directory in a memFS.
+type memFileInfo struct {
+	name string
+	size int64
+	mode fs.FileMode
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return fi.size }
+func (fi memFileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi memFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi memFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi memFileInfo) Sys() interface{}   { return nil }
+
+type memFile struct {
+	bytes.Buffer
+	m    *MemOutput
+	name string
+}
+
+func (f *memFile) Close() error {
+	f.m.mu.Lock()
+	defer f.m.mu.Unlock()
+	f.m.files[f.name] = f.Bytes()
+	return nil
+}
+
+// ArchiveOutput collects files in memory, then writes them out as a
+// single txtar or zip archive when closed. Files appear in the
+// archive sorted by name, so the archive contents don't depend on
+// the order in which files were generated.
+type ArchiveOutput struct {
+	*MemOutput
+	w     io.Writer
+	iszip bool
+}
+
+// NewTxtarOutput returns an Output that writes a txtar archive
+// (see golang.org/x/tools/txtar) to 'w' once closed.
+func NewTxtarOutput(w io.Writer) *ArchiveOutput {
+	return &ArchiveOutput{MemOutput: NewMemOutput(), w: w}
+}
+
+// NewZipOutput returns an Output that writes a zip archive to 'w'
+// once closed.
+func NewZipOutput(w io.Writer) *ArchiveOutput {
+	return &ArchiveOutput{MemOutput: NewMemOutput(), w: w, iszip: true}
+}
+
+// Close writes the archive; it must be called after Generate
+// returns.
+func (a *ArchiveOutput) Close() error {
+	files := a.Files()
+	if a.iszip {
+		zw := zip.NewWriter(a.w)
+		for _, n := range a.Names() {
+			fw, err := zw.Create(n)
+			if err != nil {
+				return err
+			}
+			if _, err := fw.Write(files[n]); err != nil {
+				return err
+			}
+		}
+		return zw.Close()
+	}
+	var b bytes.Buffer
+	for _, n := range a.Names() {
+		c := files[n]
+		fmt.Fprintf(&b, "-- %s --\n", n)
+		b.Write(c)
+		if len(c) != 0 && c[len(c)-1] != '\n' {
+			b.WriteString("\n")
+		}
+	}
+	_, err := b.WriteTo(a.w)
+	return err
+}
+
+// ParseTxtar returns the files in txtar archive 'data', as written by
+// the Output returned by NewTxtarOutput, keyed by name. Any text
+// before the first file marker is ignored.
+func ParseTxtar(data []byte) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	name := ""
+	for len(data) != 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line = data[:i+1]
+		}
+		data = data[len(line):]
+		if bytes.HasPrefix(line, []byte("-- ")) && bytes.HasSuffix(line, []byte(" --\n")) {
+			name = string(line[3 : len(line)-4])
+			if _, ok := files[name]; ok || !fs.ValidPath(name) {
+				return nil, fmt.Errorf("bad or duplicate file name %q in txtar archive", name)
+			}
+			files[name] = []byte{}
+			continue
+		}
+		if name != "" {
+			files[name] = append(files[name], line...)
+		}
+	}
+	return files, nil
+}
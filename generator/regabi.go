@@ -0,0 +1,231 @@
+package generator
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// abiRegs describes the registers available for passing arguments
+// and results under the Go internal (register-based) ABI on a given
+// architecture. An architecture that still uses the stack-based ABI
+// has zero registers of each class.
+type abiRegs struct {
+	ints    int
+	floats  int
+	ptrSize int
+}
+
+var archRegs = map[string]abiRegs{
+	"386":      {ptrSize: 4},
+	"amd64":    {ints: 9, floats: 15, ptrSize: 8},
+	"arm":      {ptrSize: 4},
+	"arm64":    {ints: 16, floats: 16, ptrSize: 8},
+	"loong64":  {ints: 16, floats: 16, ptrSize: 8},
+	"mips":     {ptrSize: 4},
+	"mipsle":   {ptrSize: 4},
+	"mips64":   {ptrSize: 8},
+	"mips64le": {ptrSize: 8},
+	"ppc64":    {ints: 12, floats: 12, ptrSize: 8},
+	"ppc64le":  {ints: 12, floats: 12, ptrSize: 8},
+	"riscv64":  {ints: 16, floats: 16, ptrSize: 8},
+	"s390x":    {ptrSize: 8},
+	"wasm":     {ptrSize: 8},
+}
+
+func (a abiRegs) hasRegABI() bool {
+	return a.ints != 0 && a.floats != 0
+}
+
+// regsFor returns the number of integer and floating point registers
+// needed to pass a value of type 'p' under the register ABI. If the
+// type can't be register-assigned at all (e.g. an array with more
+// than one element), the third return value is false.
+func (a abiRegs) regsFor(p parm) (int, int, bool) {
+	switch x := p.(type) {
+	case *numparm:
+		switch x.tag {
+		case "float":
+			return 0, 1, true
+		case "complex":
+			return 0, 2, true
+		}
+		if int(x.widthInBits) > a.ptrSize*8 {
+			return 2, 0, true
+		}
+		return 1, 0, true
+	case *stringparm, *interfaceparm:
+		return 2, 0, true
+	case *pointerparm, *mapparm:
+		return 1, 0, true
+	case *typedefparm:
+		return a.regsFor(x.target)
+	case *arrayparm:
+		if x.slice {
+			return 3, 0, true
+		}
+		switch x.nelements {
+		case 0:
+			return 0, 0, true
+		case 1:
+			return a.regsFor(x.eltype)
+		}
+		return 0, 0, false
+	case *structparm:
+		ni, nf := 0, 0
+		for _, fld := range x.fields {
+			fi, ff, ok := a.regsFor(fld)
+			if !ok {
+				return 0, 0, false
+			}
+			ni += fi
+			nf += ff
+		}
+		return ni, nf, true
+	}
+	panic(fmt.Sprintf("unhandled parm %s in regsFor", p.String()))
+}
+
+// assignRegs simulates register assignment for a list of params
+// following the rules of the Go internal ABI, returning the number
+// of integer and floating point registers consumed and the indices
+// of the params that wind up being passed in memory.
+func (a abiRegs) assignRegs(parms []parm) (int, int, []int) {
+	ni, nf := 0, 0
+	spilled := []int{}
+	for pi, p := range parms {
+		fi, ff, ok := a.regsFor(p)
+		if !ok || ni+fi > a.ints || nf+ff > a.floats {
+			spilled = append(spilled, pi)
+			continue
+		}
+		ni += fi
+		nf += ff
+	}
+	return ni, nf, spilled
+}
+
+// genRegScalar returns a scalar param of the specified register
+// class that needs at most 'max' registers.
+func (s *genstate) genRegScalar(float bool, max int) parm {
+	a := archRegs[s.tunables.goarch]
+	which := s.wr.Intn(100)
+	if float {
+		var fp numparm
+		fp.tag = "float"
+		fp.widthInBits = s.floatBits()
+		if max >= 2 && which < 20 {
+			fp.tag = "complex"
+			fp.widthInBits *= 2
+		}
+		return &fp
+	}
+	switch {
+	case max >= 2 && which < 15:
+		var sp stringparm
+		sp.tag = "string"
+		return &sp
+	case which < 30:
+		pp := mkPointerParm(&numparm{tag: "int", widthInBits: 64})
+		return &pp
+	}
+	var ip numparm
+	ip.tag = s.intFlavor()
+	ip.widthInBits = s.intBits()
+	if ni, _, _ := a.regsFor(&ip); ni > max {
+		ip.widthInBits = 32
+	}
+	return &ip
+}
+
+// genRegStruct returns a struct param containing 'nf' scalar fields
+// of the specified register class, each needing a single register.
+func (s *genstate) genRegStruct(f *funcdef, float bool, nf int, pidx int) parm {
+	var sp structparm
+	ns := len(f.structdefs)
+	sp.sname = fmt.Sprintf("StructF%dS%d", f.idx, ns)
+	sp.qname = fmt.Sprintf("%s.StructF%dS%d", s.checkerPkg(pidx), f.idx, ns)
+	f.structdefs = append(f.structdefs, sp)
+	for fi := 0; fi < nf; fi++ {
+		var fp numparm
+		if float {
+			fp.tag = "float"
+			fp.widthInBits = s.floatBits()
+		} else {
+			fp.tag = s.intFlavor()
+			fp.widthInBits = 32
+		}
+		sp.fields = append(sp.fields, &fp)
+	}
+	f.structdefs[ns] = sp
+	return &sp
+}
+
+// paramRegs returns the registers available for the params of a test
+// function on the target architecture, which is one integer register
+// fewer than the full set, since the hidden leading context param
+// (see ctxParam) takes the first one.
+func (s *genstate) paramRegs() abiRegs {
+	a := archRegs[s.tunables.goarch]
+	a.ints--
+	return a
+}
+
+// genRegBoundaryParms returns a list of params whose register
+// requirements sit right at the edge of what is available in 'a':
+// either N-1, N or N+1 registers of a given class, or a struct that
+// needs one more register than is left (which forces the entire
+// struct into memory) followed by a scalar that should still be
+// register-assigned.
+func (s *genstate) genRegBoundaryParms(f *funcdef, a abiRegs, pidx int) []parm {
+	float := s.wr.Intn(100) < 50
+	n := a.ints
+	if float {
+		n = a.floats
+	}
+	parms := []parm{}
+	used := 0
+	fill := func(target int) {
+		for used < target {
+			p := s.genRegScalar(float, target-used)
+			ni, nf, _ := a.regsFor(p)
+			used += ni + nf
+			parms = append(parms, p)
+		}
+	}
+	which := s.wr.Intn(4)
+	if which < 3 {
+		fill(n - 1 + which)
+		return parms
+	}
+	left := 1 + s.wr.Intn(3)
+	if left > n {
+		left = n
+	}
+	fill(n - left)
+	parms = append(parms, s.genRegStruct(f, float, left+1, pidx))
+	parms = append(parms, s.genRegScalar(float, 1))
+	return parms
+}
+
+// emitRegComment writes a comment describing how the params and
+// returns of 'f' are expected to be assigned to registers on the
+// target architecture. The param counts leave out the register
+// taken by the context param.
+func (s *genstate) emitRegComment(f *funcdef, b *bytes.Buffer) {
+	lists := [][]parm{f.params, f.returns}
+	names := []string{"p", "r"}
+	abis := []abiRegs{s.paramRegs(), archRegs[s.tunables.goarch]}
+	for i, lst := range lists {
+		a := abis[i]
+		ni, nf, spilled := a.assignRegs(lst)
+		b.WriteString(fmt.Sprintf("// regabi(%s) %s: %d/%d int, %d/%d float regs",
+			s.tunables.goarch, names[i], ni, a.ints, nf, a.floats))
+		for k, si := range spilled {
+			if k == 0 {
+				b.WriteString(", in memory:")
+			}
+			b.WriteString(fmt.Sprintf(" %s%d", names[i], si))
+		}
+		b.WriteString("\n")
+	}
+}
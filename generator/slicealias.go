@@ -0,0 +1,173 @@
+package generator
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// sliceAlias describes a group of slice params that all share a
+// single backing array "ab", each param being the sub-slice
+// ab[lo:hi:max]. A group with a single member is instead created
+// with make() and then filled in from the backing array, so that
+// it has len < cap but doesn't alias anything.
+type sliceAlias struct {
+	eltype  parm
+	nback   int
+	members []aliasMember
+}
+
+type aliasMember struct {
+	pidx, lo, hi, max int
+}
+
+// member returns the alias group member corresponding to param
+// 'pi', or nil if the param isn't part of the group.
+func (a *sliceAlias) member(pi int) *aliasMember {
+	if a == nil {
+		return nil
+	}
+	for k := range a.members {
+		if a.members[k].pidx == pi {
+			return &a.members[k]
+		}
+	}
+	return nil
+}
+
+// genSliceAlias creates a new alias group for 'f' and inserts its
+// members at random positions within the param list.
+func (s *genstate) genSliceAlias(f *funcdef, pidx int) {
+	a := new(sliceAlias)
+	f.alias = a
+	a.eltype = s.GenParm(f, int(s.tunables.structDepth), false, pidx)
+	a.eltype.SetBlank(false)
+	a.eltype.SetSkipCompare(SkipNone)
+	a.nback = 2 + s.wr.Intn(6)
+	nm := 1 + s.wr.Intn(3)
+	for k := 0; k < nm; k++ {
+		lo := s.wr.Intn(a.nback)
+		hi := lo + s.wr.Intn(a.nback-lo+1)
+		max := hi + s.wr.Intn(a.nback-hi+1)
+		var ap arrayparm
+		ns := len(f.arraydefs)
+		ap.aname = fmt.Sprintf("ArrayF%dS%dE%d", f.idx, ns, hi-lo)
+		ap.qname = fmt.Sprintf("%s.%s", s.checkerPkg(pidx), ap.aname)
+		ap.nelements = uint8(hi - lo)
+		ap.slice = true
+		ap.eltype = a.eltype
+		f.arraydefs = append(f.arraydefs, ap)
+
+		// Insert the new param, shifting existing members as needed.
+		pos := s.wr.Intn(len(f.params) + 1)
+		s.insertParm(f, pos, &ap)
+		a.members = append(a.members, aliasMember{pos, lo, hi, max})
+	}
+}
+
+// emitAliasBacking emits the declaration of the backing array
+// for the alias group of 'f', if there is one.
+func (s *genstate) emitAliasBacking(f *funcdef, b *bytes.Buffer, value int, caller bool) int {
+	a := f.alias
+	if a == nil {
+		return value
+	}
+	tn := a.eltype.TypeName()
+	if caller {
+		tn = a.eltype.QualName()
+	}
+	b.WriteString(fmt.Sprintf("  ab := []%s{", tn))
+	for i := 0; i < a.nback; i++ {
+		var valstr string
+		valstr, value = s.GenValue(f, a.eltype, value, caller)
+		writeCom(b, i)
+		b.WriteString(valstr)
+	}
+	b.WriteString("}\n")
+	if !caller {
+		b.WriteString("  _ = ab\n")
+	}
+	return value
+}
+
+// emitAliasMemberAssign emits code in the caller to create the
+// value of alias group member 'm'.
+func (s *genstate) emitAliasMemberAssign(f *funcdef, b *bytes.Buffer, m *aliasMember) {
+	p := f.params[m.pidx]
+	if len(f.alias.members) == 1 {
+		b.WriteString(fmt.Sprintf("  p%d := make(%s, %d, %d)\n",
+			m.pidx, p.QualName(), m.hi-m.lo, m.max-m.lo))
+		b.WriteString(fmt.Sprintf("  copy(p%d, ab[%d:%d])\n", m.pidx, m.lo, m.hi))
+		return
+	}
+	b.WriteString(fmt.Sprintf("  p%d := %s(ab[%d:%d:%d])\n",
+		m.pidx, p.QualName(), m.lo, m.hi, m.max))
+}
+
+// emitAliasMemberChecks emits code in the checker to verify the
+// len, cap and elements of alias group member 'm'.
+func (s *genstate) emitAliasMemberChecks(f *funcdef, b *bytes.Buffer, m *aliasMember) {
+	p := f.params[m.pidx]
+	ref := s.genParamRef(p, m.pidx)
+	cm := f.complexityMeasure()
+	b.WriteString(fmt.Sprintf("  // p%d is ab[%d:%d:%d]\n", m.pidx, m.lo, m.hi, m.max))
+	b.WriteString(fmt.Sprintf("  if len(%s) != %d || cap(%s) != %d {\n",
+		ref, m.hi-m.lo, ref, m.max-m.lo))
+	b.WriteString(fmt.Sprintf("    ctx.NoteFailure(%d, %d, \"%s\", \"slice header\", %d, false, pad[0])\n", cm, f.idx, s.checkerPkg(s.pkidx), m.pidx))
+	b.WriteString("    return\n")
+	b.WriteString("  }\n")
+	for i := 0; i < m.hi-m.lo; i++ {
+		elref, elparm := p.GenElemRef(i, ref)
+		basep, _ := genDeref(elparm)
+		if basep.NumElements() == 0 {
+			continue
+		}
+		cvar := fmt.Sprintf("p%df%dc", m.pidx, i)
+		b.WriteString(fmt.Sprintf("  %s := ab[%d]\n", cvar, m.lo+i))
+		s.emitParamElemCheck(f, b, elparm, elref, cvar, m.pidx, i)
+	}
+}
+
+// emitAliasIdentityChecks emits code in the checker to verify that
+// overlapping members of the alias group of 'f' really do share
+// memory: first by comparing element addresses, then (for integer
+// element types) by writing through one member and reading back
+// through the other.
+func (s *genstate) emitAliasIdentityChecks(f *funcdef, b *bytes.Buffer) {
+	a := f.alias
+	if a == nil || len(a.members) < 2 {
+		return
+	}
+	cm := f.complexityMeasure()
+	np, isnum := a.eltype.(*numparm)
+	probe := isnum && (np.tag == "int" || np.tag == "uint" || np.tag == "byte")
+	for j, mj := range a.members {
+		for _, mk := range a.members[j+1:] {
+			lo, hi := mj.lo, mj.hi
+			if mk.lo > lo {
+				lo = mk.lo
+			}
+			if mk.hi < hi {
+				hi = mk.hi
+			}
+			if lo >= hi {
+				continue
+			}
+			rj := fmt.Sprintf("%s[%d]", s.genParamRef(f.params[mj.pidx], mj.pidx), lo-mj.lo)
+			rk := fmt.Sprintf("%s[%d]", s.genParamRef(f.params[mk.pidx], mk.pidx), lo-mk.lo)
+			fail := fmt.Sprintf("    ctx.NoteFailure(%d, %d, \"%s\", \"alias\", %d, false, pad[0])\n    return\n  }\n", cm, f.idx, s.checkerPkg(s.pkidx), mk.pidx)
+			b.WriteString(fmt.Sprintf("  // p%d and p%d share ab[%d]\n", mj.pidx, mk.pidx, lo))
+			b.WriteString(fmt.Sprintf("  if &%s != &%s {\n", rj, rk))
+			b.WriteString(fail)
+			if probe {
+				b.WriteString("  {\n")
+				b.WriteString(fmt.Sprintf("  sv := %s\n", rj))
+				b.WriteString(fmt.Sprintf("  %s = sv + 1\n", rj))
+				b.WriteString(fmt.Sprintf("  nv := %s\n", rk))
+				b.WriteString(fmt.Sprintf("  %s = sv\n", rj))
+				b.WriteString("  if nv != sv+1 {\n")
+				b.WriteString(fail)
+				b.WriteString("  }\n")
+			}
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package generator
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// Specs is a set of test function signatures supplied by the user,
+// read from a spec file with ParseSpecs. When GenConfig.Specs is
+// set, each test package gets one test function per signature, in
+// place of the randomly generated ones. Each one has exactly the
+// params and results of its signature, with no receiver or hidden
+// params added; everything else about the test functions (values,
+// defer/go/reflect checks, address taking and so on) is chosen at
+// random as usual.
+//
+// A spec file has one Go func type expression per line, e.g.
+//
+//	func(int8, struct{F0 float32; F1 [0]int64}) (string, *uint16)
+//
+// along with "type Name T" lines declaring names that later lines
+// can use. Parameters named "_" (and struct fields named "_") are
+// blank. Blank lines and lines starting with "//" are ignored.
+//
+// Only the layout of the types is preserved: other struct field
+// names are replaced by F0, F1 and so on, and each use of a declared
+// type name gets its own distinct generated type.
+type Specs struct {
+	src   string
+	funcs []*ast.FuncType
+	types map[string]ast.Expr
+}
+
+// predeclared maps the predeclared type names supported in specs to
+// the parms that stand for them; "int", "uint" and "uintptr" are
+// handled separately, since their size depends on the target.
+var predeclared = map[string]parm{
+	"int8":       &numparm{tag: "int", widthInBits: 8},
+	"int16":      &numparm{tag: "int", widthInBits: 16},
+	"int32":      &numparm{tag: "int", widthInBits: 32},
+	"rune":       &numparm{tag: "int", widthInBits: 32},
+	"int64":      &numparm{tag: "int", widthInBits: 64},
+	"uint8":      &numparm{tag: "uint", widthInBits: 8},
+	"uint16":     &numparm{tag: "uint", widthInBits: 16},
+	"uint32":     &numparm{tag: "uint", widthInBits: 32},
+	"uint64":     &numparm{tag: "uint", widthInBits: 64},
+	"byte":       &numparm{tag: "byte", widthInBits: 8},
+	"float32":    &numparm{tag: "float", widthInBits: 32},
+	"float64":    &numparm{tag: "float", widthInBits: 64},
+	"complex64":  &numparm{tag: "complex", widthInBits: 64},
+	"complex128": &numparm{tag: "complex", widthInBits: 128},
+	"string":     &stringparm{tag: "string"},
+	"any":        &interfaceparm{},
+	"int":        nil,
+	"uint":       nil,
+	"uintptr":    nil,
+}
+
+// ParseSpecs parses the spec file 'src' (see Specs); 'filename' is
+// used in error messages.
+func ParseSpecs(filename string, src []byte) (*Specs, error) {
+	sp := &Specs{src: string(src), types: make(map[string]ast.Expr)}
+	sc := bufio.NewScanner(bytes.NewReader(src))
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "//") {
+			continue
+		}
+		if err := sp.parseLine(text); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", filename, line, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(sp.funcs) == 0 {
+		return nil, fmt.Errorf("%s: no function signatures", filename)
+	}
+	return sp, nil
+}
+
+func (sp *Specs) parseLine(text string) error {
+	if strings.HasPrefix(text, "type ") {
+		f := strings.Fields(text)
+		if len(f) < 3 || !token.IsIdentifier(f[1]) {
+			return fmt.Errorf("malformed type declaration")
+		}
+		name := f[1]
+		if _, ok := predeclared[name]; ok || sp.types[name] != nil {
+			return fmt.Errorf("type %s redeclared", name)
+		}
+		rest := strings.TrimSpace(text[strings.Index(text, name)+len(name):])
+		rest = strings.TrimPrefix(rest, "=")
+		e, err := parser.ParseExpr(rest)
+		if err != nil {
+			return err
+		}
+		sp.types[name] = e
+		return sp.check(e, false, map[string]bool{name: true})
+	}
+	e, err := parser.ParseExpr(text)
+	if err != nil {
+		return err
+	}
+	ft, ok := e.(*ast.FuncType)
+	if !ok {
+		return fmt.Errorf("not a func type")
+	}
+	for _, fl := range []*ast.FieldList{ft.Params, ft.Results} {
+		if fl == nil {
+			continue
+		}
+		for _, fld := range fl.List {
+			if _, ok := fld.Type.(*ast.Ellipsis); ok {
+				return fmt.Errorf("variadic functions not supported")
+			}
+			if err := sp.check(fld.Type, false, map[string]bool{}); err != nil {
+				return err
+			}
+		}
+	}
+	sp.funcs = append(sp.funcs, ft)
+	return nil
+}
+
+// check verifies that type expression 'e' only uses supported types.
+// 'inkey' is true within map key types, and 'active' holds the names
+// of the types being expanded (so as to detect recursive types).
+func (sp *Specs) check(e ast.Expr, inkey bool, active map[string]bool) error {
+	switch x := e.(type) {
+	case *ast.Ident:
+		if p, ok := predeclared[x.Name]; ok {
+			if _, isif := p.(*interfaceparm); isif && inkey {
+				return fmt.Errorf("interface map keys not supported")
+			}
+			return nil
+		}
+		t, ok := sp.types[x.Name]
+		if !ok {
+			return fmt.Errorf("unknown or unsupported type %s", x.Name)
+		}
+		if active[x.Name] {
+			return fmt.Errorf("recursive type %s not supported", x.Name)
+		}
+		active[x.Name] = true
+		defer delete(active, x.Name)
+		return sp.check(t, inkey, active)
+	case *ast.ParenExpr:
+		return sp.check(x.X, inkey, active)
+	case *ast.StarExpr:
+		if inkey {
+			return fmt.Errorf("pointer map keys not supported")
+		}
+		return sp.check(x.X, inkey, active)
+	case *ast.ArrayType:
+		if x.Len == nil {
+			if inkey {
+				return fmt.Errorf("invalid map key type (slice)")
+			}
+		} else if _, err := arrayLen(x); err != nil {
+			return err
+		}
+		return sp.check(x.Elt, inkey, active)
+	case *ast.MapType:
+		if inkey {
+			return fmt.Errorf("invalid map key type (map)")
+		}
+		if err := sp.check(x.Key, true, active); err != nil {
+			return err
+		}
+		return sp.check(x.Value, false, active)
+	case *ast.StructType:
+		for _, fld := range x.Fields.List {
+			if err := sp.check(fld.Type, inkey, active); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *ast.InterfaceType:
+		if len(x.Methods.List) != 0 {
+			return fmt.Errorf("non-empty interfaces not supported")
+		}
+		if inkey {
+			return fmt.Errorf("interface map keys not supported")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported type %s", specTypeDesc(e))
+}
+
+// arrayLen returns the length of array type 'x'.
+func arrayLen(x *ast.ArrayType) (int, error) {
+	lit, ok := x.Len.(*ast.BasicLit)
+	if !ok || lit.Kind != token.INT {
+		return 0, fmt.Errorf("array length must be an integer literal")
+	}
+	n, err := strconv.ParseUint(lit.Value, 0, 8)
+	if err != nil {
+		return 0, fmt.Errorf("array length %s out of range", lit.Value)
+	}
+	return int(n), nil
+}
+
+// specTypeDesc returns a short description of 'e' for use in error
+// messages.
+func specTypeDesc(e ast.Expr) string {
+	switch x := e.(type) {
+	case *ast.FuncType:
+		return "func"
+	case *ast.ChanType:
+		return "chan"
+	case *ast.SelectorExpr:
+		if id, ok := x.X.(*ast.Ident); ok {
+			return id.Name + "." + x.Sel.Name
+		}
+	}
+	return fmt.Sprintf("%T", e)
+}
+
+// genSpecParms returns the params (or results) of function 'f' as
+// given by the spec field list 'fl'.
+func (s *genstate) genSpecParms(f *funcdef, fl *ast.FieldList, pidx int) []parm {
+	var parms []parm
+	if fl == nil {
+		return parms
+	}
+	for _, fld := range fl.List {
+		names := []string{""}
+		if len(fld.Names) != 0 {
+			names = nil
+			for _, n := range fld.Names {
+				names = append(names, n.Name)
+			}
+		}
+		for _, n := range names {
+			p := s.genSpecParm(f, fld.Type, 0, pidx)
+			isblank := n == "_"
+			p.SetBlank(isblank)
+			if tunables.takeAddress && !isblank {
+				p.SetAddrTaken(s.genAddrTaken())
+			}
+			parms = append(parms, p)
+		}
+	}
+	return parms
+}
+
+// genSpecParm returns a new parm for spec type expression 'e', in
+// the same way that GenParm does for random types.
+func (s *genstate) genSpecParm(f *funcdef, e ast.Expr, depth int, pidx int) parm {
+	var retval parm
+	switch x := e.(type) {
+	case *ast.Ident:
+		if t, ok := s.specs.types[x.Name]; ok {
+			retval = s.makeTypedefParm(f, s.genSpecParm(f, t, depth, pidx), pidx)
+			break
+		}
+		switch p := predeclared[x.Name].(type) {
+		case *numparm:
+			np := *p
+			retval = &np
+		case *stringparm:
+			sp := *p
+			retval = &sp
+		case *interfaceparm:
+			retval = &interfaceparm{}
+		default:
+			// int, uint, uintptr
+			tag := "uint"
+			if x.Name == "int" {
+				tag = "int"
+			}
+			bits := 8 * archRegs[s.tunables.goarch].ptrSize
+			retval = &numparm{tag: tag, widthInBits: uint32(bits)}
+		}
+	case *ast.ParenExpr:
+		return s.genSpecParm(f, x.X, depth, pidx)
+	case *ast.StarExpr:
+		pp := mkPointerParm(s.genSpecParm(f, x.X, depth+1, pidx))
+		retval = &pp
+	case *ast.InterfaceType:
+		retval = &interfaceparm{}
+	case *ast.ArrayType:
+		var ap arrayparm
+		ns := len(f.arraydefs)
+		f.arraydefs = append(f.arraydefs, ap)
+		var nel int
+		if x.Len == nil {
+			ap.slice = true
+			nel = s.wr.Intn(int(s.tunables.nArrayElements))
+		} else {
+			nel, _ = arrayLen(x)
+		}
+		ap.aname = fmt.Sprintf("ArrayF%dS%dE%d", f.idx, ns, nel)
+		ap.qname = fmt.Sprintf("%s.%s", s.checkerPkg(pidx), ap.aname)
+		ap.nelements = uint8(nel)
+		ap.eltype = s.genSpecParm(f, x.Elt, depth+1, pidx)
+		ap.eltype.SetBlank(false)
+		if ap.slice && nel == 0 {
+			ap.isnil = uint8(s.wr.Intn(100)) < s.tunables.nilFraction
+		}
+		f.arraydefs[ns] = ap
+		retval = &ap
+	case *ast.MapType:
+		key := s.genSpecParm(f, x.Key, depth+1, pidx)
+		val := s.genSpecParm(f, x.Value, depth+1, pidx)
+		retval = s.genMapParm(f, depth, pidx, key, val)
+	case *ast.StructType:
+		var sp structparm
+		ns := len(f.structdefs)
+		sp.sname = fmt.Sprintf("StructF%dS%d", f.idx, ns)
+		sp.qname = fmt.Sprintf("%s.%s", s.checkerPkg(pidx), sp.sname)
+		f.structdefs = append(f.structdefs, sp)
+		for _, fld := range x.Fields.List {
+			n := len(fld.Names)
+			if n == 0 {
+				n = 1
+			}
+			for i := 0; i < n; i++ {
+				fp := s.genSpecParm(f, fld.Type, depth+1, pidx)
+				fp.SetBlank(i < len(fld.Names) && fld.Names[i].Name == "_")
+				skComp := tunables.doSkipCompare &&
+					uint8(s.wr.Intn(100)) < s.tunables.skipCompareFraction
+				if skComp && checkableElements(fp) != 0 {
+					fp.SetSkipCompare(SkipAll)
+				}
+				sp.fields = append(sp.fields, fp)
+			}
+		}
+		f.structdefs[ns] = sp
+		retval = &sp
+	default:
+		panic(fmt.Sprintf("unexpected spec type %T", e))
+	}
+	retval.SetBlank(false)
+	retval.SetIsGenVal(tunables.doFuncCallValues &&
+		uint8(s.wr.Intn(100)) < s.tunables.funcCallValFraction)
+	return retval
+}
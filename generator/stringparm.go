@@ -2,12 +2,17 @@ package generator
 
 import (
 	"bytes"
+	"fmt"
+	"strconv"
 )
 
 // stringparm describes a parameter of string type; it implements the
 // "parm" interface
 type stringparm struct {
 	tag string
+	// for top-level params and results, true if the value is
+	// passed via a []byte -> string conversion
+	viaBytes bool
 	isBlank
 	addrTakenHow
 	isGenValFunc
@@ -24,15 +29,54 @@ func (p stringparm) GenElemRef(elidx int, path string) (string, parm) {
 
 var letters = []rune("�꿦3򂨃f6ꂅ8ˋ<􂊇񊶿(z̽|ϣᇊ񁗇򟄼q񧲥筁{ЂƜĽ")
 
+// String value flavors, selected according to the
+// stringKindFractions tunable.
+const (
+	strEmpty = iota
+	strShort
+	strLong
+	strRawBytes
+	strShared
+)
+
+// sharedStrLen is the length of the SharedStr global emitted into
+// the utils package, substrings of which are used as string values.
+const sharedStrLen = 4096
+
 func (p stringparm) GenValue(s *genstate, f *funcdef, value int, caller bool) (string, int) {
+	switch s.stringKind() {
+	case strEmpty:
+		return "\"\"", value + 1
+	case strLong:
+		chunk := genShortString(s, 1)
+		n := 256 + s.wr.Intn(1024)
+		return fmt.Sprintf("%s.RepeatStr(%s, %d)", s.utilsPkg(), chunk, n), value + 1
+	case strRawBytes:
+		// arbitrary bytes, including NULs and invalid UTF-8
+		bs := make([]byte, s.wr.Intn(16))
+		for i := range bs {
+			bs[i] = byte(s.wr.Intn(256))
+		}
+		return strconv.Quote(string(bs)), value + 1
+	case strShared:
+		st := s.wr.Intn(sharedStrLen)
+		en := st + 1 + s.wr.Intn(sharedStrLen-st)
+		return fmt.Sprintf("%s.SharedStr[%d:%d]", s.utilsPkg(), st, en), value + 1
+	}
+	return genShortString(s, 0), value + 1
+}
+
+// genShortString returns a string literal containing at least
+// 'min' (and at most 8) runes.
+func genShortString(s *genstate, min int) string {
 	ns := len(letters) - 9
-	nel := s.wr.Intn(8)
+	nel := min + s.wr.Intn(8-min)
 	st := s.wr.Intn(ns)
 	en := st + nel
 	if en > ns {
 		en = ns
 	}
-	return "\"" + string(letters[st:en]) + "\"", value + 1
+	return "\"" + string(letters[st:en]) + "\""
 }
 
 func (p stringparm) IsControl() bool {
@@ -0,0 +1,45 @@
+// Package frompkg is a fixture for TestSpecsFromPackage. Its exported
+// functions and methods cover each of the type replacements that
+// SpecsFromPackage makes, along with signatures that it must skip.
+package frompkg
+
+import "unsafe"
+
+type Pair struct {
+	A int8
+	b float32
+}
+
+// List refers to itself, through a pointer.
+type List struct {
+	Next *List
+	V    int32
+}
+
+type Stringer interface {
+	String() string
+}
+
+func Repeat(s string, n int) string { return s }
+
+func Cut(s, sep string) (before, after string, found bool) { return s, sep, false }
+
+func (p *Pair) Set(a int8, ch chan int) error { return nil }
+
+func (p Pair) Sum() float64 { return 0 }
+
+func Walk(l *List, fn func(int32)) unsafe.Pointer { return nil }
+
+func Show(s Stringer, m map[string]uintptr) [2]complex64 { return [2]complex64{} }
+
+// Signatures that can't be represented.
+
+func Ident[T any](x T) T { return x }
+
+func Big(a [1000]int8) {}
+
+func ByPtr(m map[*int8]int8) {}
+
+// Unexported functions aren't included.
+
+func hidden(x int8) {}
@@ -0,0 +1,7 @@
+// Package generic is a fixture for TestSpecsFromPackage, with only
+// generic functions, none of which SpecsFromPackage can represent.
+package generic
+
+func Ident[T any](x T) T { return x }
+
+func First[T any](xs []T) T { return xs[0] }
@@ -0,0 +1,157 @@
+package generator
+
+import (
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// typeChecker validates generated code in-process using go/types.
+// Imports of generated packages are satisfied by type-checking the
+// in-memory sources; everything else (the standard library) comes
+// from stdImporter.
+type typeChecker struct {
+	fset   *token.FileSet
+	srcs   map[string][]*genFile // generated sources by import path
+	pkgs   map[string]*types.Package
+	decls  map[string][]declRegion // by file name
+	errs   []string
+	active map[string]bool
+}
+
+// stdImporter is the source importer for standard library packages.
+// It is shared by all type checks (under stdMu), so that each package
+// is only type-checked once per process, rather than once per call
+// to Generate.
+var (
+	stdMu       sync.Mutex
+	stdImporter = importer.ForCompiler(token.NewFileSet(), "source", nil)
+)
+
+// declRegion records the (formatted) line range of a top-level
+// declaration and the test function that produced it, if any.
+type declRegion struct {
+	start, end int
+	r          *funcRegion
+}
+
+// typeCheck type-checks the generated files in 'files', returning a
+// description of each error found. File names are mapped to import
+// paths using the import prefix 'ipref'; files at top level belong
+// to package 'mainpath'.
+func typeCheck(files []*genFile, ipref string, mainpath string) []string {
+	fset := token.NewFileSet()
+	tc := &typeChecker{
+		fset:   fset,
+		srcs:   make(map[string][]*genFile),
+		pkgs:   make(map[string]*types.Package),
+		decls:  make(map[string][]declRegion),
+		active: make(map[string]bool),
+	}
+	for _, gf := range files {
+		ip := mainpath
+		if dir := path.Dir(gf.name); dir != "." {
+			ip = ipref + dir
+		}
+		tc.srcs[ip] = append(tc.srcs[ip], gf)
+	}
+	paths := make([]string, 0, len(tc.srcs))
+	for ip := range tc.srcs {
+		paths = append(paths, ip)
+	}
+	sort.Strings(paths)
+	for _, ip := range paths {
+		if _, err := tc.Import(ip); err != nil {
+			tc.errs = append(tc.errs, fmt.Sprintf("type-checking %s: %v", ip, err))
+		}
+	}
+	return tc.errs
+}
+
+func (tc *typeChecker) Import(ip string) (*types.Package, error) {
+	if pkg, ok := tc.pkgs[ip]; ok {
+		return pkg, nil
+	}
+	gfs, ok := tc.srcs[ip]
+	if !ok {
+		stdMu.Lock()
+		defer stdMu.Unlock()
+		return stdImporter.Import(ip)
+	}
+	if tc.active[ip] {
+		return nil, fmt.Errorf("import cycle through %s", ip)
+	}
+	tc.active[ip] = true
+	defer delete(tc.active, ip)
+
+	var afiles []*ast.File
+	for _, gf := range gfs {
+		af, err := parser.ParseFile(tc.fset, gf.name, gf.src, parser.ParseComments)
+		if err != nil {
+			// already reported when formatting
+			return nil, err
+		}
+		afiles = append(afiles, af)
+		tc.decls[gf.name] = tc.mapDecls(gf, af)
+	}
+	conf := types.Config{
+		Importer:  tc,
+		GoVersion: "go" + genGoVersion,
+		Error: func(err error) {
+			tc.report(err.(types.Error))
+		},
+	}
+	pkg, _ := conf.Check(ip, tc.fset, afiles, nil)
+	tc.pkgs[ip] = pkg
+	return pkg, nil
+}
+
+// mapDecls works out which test function produced each top-level
+// declaration in the formatted file 'af'. Formatting doesn't change
+// the number or order of declarations, so the i-th declaration of
+// the formatted file corresponds to the i-th declaration of the
+// unformatted source, whose offset gives us the function region.
+func (tc *typeChecker) mapDecls(gf *genFile, af *ast.File) []declRegion {
+	ufset := token.NewFileSet()
+	uf, err := parser.ParseFile(ufset, gf.name, gf.buf.Bytes(), parser.ParseComments)
+	if err != nil || len(uf.Decls) != len(af.Decls) {
+		return nil
+	}
+	var drs []declRegion
+	for i, d := range af.Decls {
+		off := ufset.Position(uf.Decls[i].Pos()).Offset
+		var fr *funcRegion
+		for k := range gf.regions {
+			if r := &gf.regions[k]; off >= r.start && off < r.end {
+				fr = r
+			}
+		}
+		drs = append(drs, declRegion{
+			start: tc.fset.Position(d.Pos()).Line,
+			end:   tc.fset.Position(d.End()).Line,
+			r:     fr,
+		})
+	}
+	return drs
+}
+
+// report records type error 'err', along with the package, test
+// function and seed responsible for it where known.
+func (tc *typeChecker) report(err types.Error) {
+	pos := tc.fset.Position(err.Pos)
+	where := ""
+	for _, dr := range tc.decls[pos.Filename] {
+		if pos.Line >= dr.start && pos.Line <= dr.end && dr.r != nil {
+			pkg := strings.TrimSuffix(path.Base(pos.Filename), ".go")
+			where = fmt.Sprintf(" (package %s, function %d, seed %d)", pkg, dr.r.fidx, dr.r.seed)
+		}
+	}
+	tc.errs = append(tc.errs, fmt.Sprintf("type error %s: %s%s", pos, err.Msg, where))
+}
@@ -15,9 +15,18 @@ const (
 	RandCtlPanic
 )
 
+// NewWrapRand returns a new random number generator seeded with
+// 'seed'. Each wraprand has its own source, so independent generators
+// can be used concurrently within one process, and nothing else that
+// uses math/rand can perturb them.
+//
+// Stability: a wraprand produces exactly the sequence of values that
+// the global math/rand functions produced after rand.Seed(seed) (the
+// historical behavior of this package), so for a given version of the
+// generator and set of tunables, a given seed always produces the
+// same generated code.
 func NewWrapRand(seed int64, ctl int) *wraprand {
-	rand.Seed(seed)
-	return &wraprand{seed: seed, ctl: ctl}
+	return &wraprand{seed: seed, ctl: ctl, rng: rand.New(rand.NewSource(seed))}
 }
 
 type wraprand struct {
@@ -28,6 +37,7 @@ type wraprand struct {
 	tag       string
 	calls     []string
 	ctl       int
+	rng       *rand.Rand
 }
 
 func (w *wraprand) captureCall(tag string, val string) {
@@ -55,7 +65,7 @@ func (w *wraprand) captureCall(tag string, val string) {
 
 func (w *wraprand) Intn(n int) int {
 	w.intncalls++
-	rv := rand.Intn(n)
+	rv := w.rng.Intn(n)
 	if w.ctl&RandCtlCapture != 0 {
 		w.captureCall("Intn", fmt.Sprintf("%d", rv))
 	}
@@ -64,7 +74,7 @@ func (w *wraprand) Intn(n int) int {
 
 func (w *wraprand) Float32() float32 {
 	w.f32calls++
-	rv := rand.Float32()
+	rv := w.rng.Float32()
 	if w.ctl&RandCtlCapture != 0 {
 		w.captureCall("Float32", fmt.Sprintf("%f", rv))
 	}
@@ -73,7 +83,7 @@ func (w *wraprand) Float32() float32 {
 
 func (w *wraprand) NormFloat64() float64 {
 	w.f64calls++
-	rv := rand.NormFloat64()
+	rv := w.rng.NormFloat64()
 	if w.ctl&RandCtlCapture != 0 {
 		w.captureCall("NormFloat64", fmt.Sprintf("%f", rv))
 	}
@@ -0,0 +1,179 @@
+package generator
+
+import (
+	"fmt"
+)
+
+// GenStats records statistics about the code emitted by Generate.
+// At the moment this is limited to the number of zero-size params
+// and returns seen in each of the positions that matter for the
+// register ABI.
+type GenStats struct {
+	// Number of functions generated in zero-size mode.
+	ZeroSizeFuncs int
+
+	// Zero-size params that come first, last, or in between other
+	// (non-zero-size) params.
+	ZeroSizeFirst   int
+	ZeroSizeLast    int
+	ZeroSizeBetween int
+
+	// Methods with zero-size receiver types.
+	ZeroSizeReceiver int
+
+	// Params and returns that are pointers to zero-size types.
+	ZeroSizePointer int
+
+	// Params and returns that are maps with zero-size values.
+	ZeroSizeMapValue int
+
+	// Zero-size returns.
+	ZeroSizeReturn int
+}
+
+func (gs GenStats) String() string {
+	return fmt.Sprintf("zero-size: %d funcs, first=%d last=%d between=%d receiver=%d pointer=%d mapvalue=%d return=%d",
+		gs.ZeroSizeFuncs, gs.ZeroSizeFirst, gs.ZeroSizeLast,
+		gs.ZeroSizeBetween, gs.ZeroSizeReceiver, gs.ZeroSizePointer,
+		gs.ZeroSizeMapValue, gs.ZeroSizeReturn)
+}
+
+// add accumulates the statistics in 'o' into 'gs'.
+func (gs *GenStats) add(o GenStats) {
+	gs.ZeroSizeFuncs += o.ZeroSizeFuncs
+	gs.ZeroSizeFirst += o.ZeroSizeFirst
+	gs.ZeroSizeLast += o.ZeroSizeLast
+	gs.ZeroSizeBetween += o.ZeroSizeBetween
+	gs.ZeroSizeReceiver += o.ZeroSizeReceiver
+	gs.ZeroSizePointer += o.ZeroSizePointer
+	gs.ZeroSizeMapValue += o.ZeroSizeMapValue
+	gs.ZeroSizeReturn += o.ZeroSizeReturn
+}
+
+// noteZeroSize adds the zero-size params and returns of 'f' to the
+// running statistics.
+func (gs *GenStats) noteZeroSize(f *funcdef) {
+	if f.zerosize {
+		gs.ZeroSizeFuncs++
+	}
+	if f.receiver != nil && isZeroSize(f.receiver) {
+		gs.ZeroSizeReceiver++
+	}
+	sized := func(p parm) bool { return !isZeroSize(p) }
+	for i, p := range f.params {
+		if !isZeroSize(p) {
+			continue
+		}
+		switch {
+		case i == 0:
+			gs.ZeroSizeFirst++
+		case i == len(f.params)-1:
+			gs.ZeroSizeLast++
+		case anyParm(f.params[:i], sized) && anyParm(f.params[i+1:], sized):
+			gs.ZeroSizeBetween++
+		}
+	}
+	for _, lst := range [][]parm{f.params, f.returns} {
+		for _, p := range lst {
+			switch x := p.(type) {
+			case *pointerparm:
+				if base, _ := genDeref(x); isZeroSize(base) {
+					gs.ZeroSizePointer++
+				}
+			case *mapparm:
+				if isZeroSize(x.valtype) {
+					gs.ZeroSizeMapValue++
+				}
+			}
+		}
+	}
+	for _, r := range f.returns {
+		if isZeroSize(r) {
+			gs.ZeroSizeReturn++
+		}
+	}
+}
+
+func anyParm(lst []parm, pred func(p parm) bool) bool {
+	for _, p := range lst {
+		if pred(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// genZeroSizeParm returns a new zero-size type for 'f': an empty
+// struct, a struct whose fields are all zero-size, a zero-length
+// array, or an array of empty structs.
+func (s *genstate) genZeroSizeParm(f *funcdef, depth int, pidx int) parm {
+	which := s.wr.Intn(4)
+	if depth > 0 {
+		// no further nesting
+		which = 2 * s.wr.Intn(2)
+	}
+	switch which {
+	case 0, 1:
+		var sp structparm
+		ns := len(f.structdefs)
+		sp.sname = fmt.Sprintf("StructF%dS%d", f.idx, ns)
+		sp.qname = fmt.Sprintf("%s.StructF%dS%d", s.checkerPkg(pidx), f.idx, ns)
+		f.structdefs = append(f.structdefs, sp)
+		if which == 1 {
+			nf := 1 + s.wr.Intn(3)
+			for fi := 0; fi < nf; fi++ {
+				sp.fields = append(sp.fields, s.genZeroSizeParm(f, depth+1, pidx))
+			}
+		}
+		f.structdefs[ns] = sp
+		return &sp
+	default:
+		var ap arrayparm
+		ns := len(f.arraydefs)
+		nel := 0
+		var elt parm
+		if which == 2 {
+			elt = &numparm{tag: s.intFlavor(), widthInBits: s.intBits()}
+		} else {
+			nel = 1 + s.wr.Intn(3)
+			elt = s.genZeroSizeParm(f, depth+1, pidx)
+		}
+		ap.aname = fmt.Sprintf("ArrayF%dS%dE%d", f.idx, ns, nel)
+		ap.qname = fmt.Sprintf("%s.%s", s.checkerPkg(pidx), ap.aname)
+		ap.nelements = uint8(nel)
+		ap.eltype = elt
+		f.arraydefs = append(f.arraydefs, ap)
+		return &ap
+	}
+}
+
+// genZeroSizeParms adds zero-size params to 'f' in each of the
+// interesting positions: first, last, in between other params
+// (which for register boundary functions means in between
+// register-assigned params), behind a pointer and as a map value.
+func (s *genstate) genZeroSizeParms(f *funcdef, pidx int) {
+	if n := len(f.params); n > 1 {
+		s.insertParm(f, 1+s.wr.Intn(n-1), s.genZeroSizeParm(f, 0, pidx))
+	}
+	if !f.regboundary {
+		// Pointers and maps would throw off the register accounting.
+		pp := mkPointerParm(s.genZeroSizeParm(f, 0, pidx))
+		s.insertParm(f, s.wr.Intn(len(f.params)+1), &pp)
+		mp := s.genMapParm(f, 0, pidx, nil, s.genZeroSizeParm(f, 1, pidx))
+		s.insertParm(f, s.wr.Intn(len(f.params)+1), mp)
+	}
+	s.insertParm(f, 0, s.genZeroSizeParm(f, 0, pidx))
+	s.insertParm(f, len(f.params), s.genZeroSizeParm(f, 0, pidx))
+}
+
+// genZeroSizeReturns adds zero-size returns to 'f', first, last
+// and (if there are enough returns) in between.
+func (s *genstate) genZeroSizeReturns(f *funcdef, pidx int) {
+	if n := len(f.returns); n > 1 {
+		pos := 1 + s.wr.Intn(n-1)
+		r := s.genZeroSizeParm(f, 0, pidx)
+		f.returns = append(f.returns[:pos], append([]parm{r}, f.returns[pos:]...)...)
+	}
+	f.returns = append([]parm{s.genZeroSizeParm(f, 0, pidx)}, f.returns...)
+	f.returns = append(f.returns, s.genZeroSizeParm(f, 0, pidx))
+}